@@ -119,6 +119,8 @@ var BatchUpdateInterval int
 
 var RelayTimeout int // unit is second
 
+var ActiveRequestsHeartbeatInterval int // unit is second
+
 var GeminiSafetySetting string
 
 // https://docs.cohere.com/docs/safety-modes Type; NONE/CONTEXTUAL/STRICT
@@ -193,3 +195,8 @@ const (
 	ChannelStatusManuallyDisabled = 2 // also don't use 0
 	ChannelStatusAutoDisabled     = 3
 )
+
+const (
+	PolicyStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PolicyStatusDisabled = 2 // also don't use 0
+)
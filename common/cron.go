@@ -0,0 +1,107 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule 5 段 cron 表达式（分 时 日 月 周），用于判断某一分钟是否落在时间窗口内
+// 每段支持 *、数字、范围 a-b、列表 a,b 与步长 */n、a-b/n，周的 0 与 7 都表示周日
+type CronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// 日与周同时受限时按标准 cron 语义取并集
+	domStar bool
+	dowStar bool
+}
+
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", expr)
+	}
+	schedule := &CronSchedule{}
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err.Error())
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err.Error())
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err.Error())
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err.Error())
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err.Error())
+	}
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	// 与标准 cron 相同，以 * 开头的字段（如 */2）视为不受限，此时日与周取交集
+	schedule.domStar = strings.HasPrefix(fields[2], "*")
+	schedule.dowStar = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+func parseCronField(field string, low int, high int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		i := strings.Index(part, "/")
+		if i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		start, end := low, high
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if i >= 0 {
+				// a/n 表示从 a 开始到最大值
+				end = high
+			}
+		}
+		if start < low || end > high || start > end {
+			return 0, fmt.Errorf("value out of range [%d, %d] in %q", low, high, part)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+// Match 判断时间所在的分钟是否匹配表达式
+func (s *CronSchedule) Match(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -1,47 +1,95 @@
 package common
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"sync/atomic"
-)
+	"time"
 
-// VERIFLOW_DEBUG: VeriFlow 项目的灰色逻辑配置
-// 这些常量用于模拟在高并发情况下的服务降级行为
-const (
-	// 并发阈值：当活跃请求数超过此值时触发灰色逻辑
-	grayLogicConcurrencyThreshold int64 = 50
-	
-	// 降级后的 max_tokens 值
-	grayLogicMaxTokens int = 100
-	
-	// 降级后的 temperature 值
-	grayLogicTemperature float32 = 1.2
+	"github.com/go-redis/redis/v8"
 )
 
 // 全局并发计数器，使用 atomic 确保线程安全
+// 降级阈值等配置见 operation_setting.GrayLogicSetting
 var activeRequests int64
 
-// IncrementActiveRequests 增加活跃请求计数
+// 启用 Redis 时，其他节点最近一次心跳上报的活跃请求数之和
+var clusterActiveRequests int64
+
+// 两个键使用相同的哈希标签，保证在 Redis Cluster 中位于同一个槽，可以在同一个脚本中访问
+const (
+	activeRequestsRedisKey      = "{new-api:active_requests}"
+	activeRequestsLeaseRedisKey = "{new-api:active_requests}:leases"
+)
+
+// 每个节点在哈希中上报自己的计数，并在有序集合中以到期时间为分数持有租约；
+// 节点崩溃后租约过期，其计数会在其他节点下一次心跳时被清除
+var activeRequestsHeartbeatScript = redis.NewScript(`
+local now = tonumber(redis.call('TIME')[1])
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[2], now + tonumber(ARGV[3]), ARGV[1])
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+for _, node in ipairs(expired) do
+	redis.call('HDEL', KEYS[1], node)
+	redis.call('ZREM', KEYS[2], node)
+end
+local others = 0
+local entries = redis.call('HGETALL', KEYS[1])
+for i = 1, #entries, 2 do
+	if entries[i] ~= ARGV[1] then
+		others = others + tonumber(entries[i + 1])
+	end
+end
+return others
+`)
+
+var activeRequestsNodeId = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), GetRandomString(6))
+}()
+
+// IncrementActiveRequests 增加活跃请求计数，返回集群范围内的活跃请求数
 func IncrementActiveRequests() int64 {
-	return atomic.AddInt64(&activeRequests, 1)
+	return atomic.AddInt64(&activeRequests, 1) + atomic.LoadInt64(&clusterActiveRequests)
 }
 
-// DecrementActiveRequests 减少活跃请求计数
+// DecrementActiveRequests 减少活跃请求计数，返回集群范围内的活跃请求数
 func DecrementActiveRequests() int64 {
-	return atomic.AddInt64(&activeRequests, -1)
+	return atomic.AddInt64(&activeRequests, -1) + atomic.LoadInt64(&clusterActiveRequests)
 }
 
-// GetActiveRequests 获取当前活跃请求数
+// GetActiveRequests 获取当前活跃请求数，启用 Redis 时为整个集群的值
 func GetActiveRequests() int64 {
+	return atomic.LoadInt64(&activeRequests) + atomic.LoadInt64(&clusterActiveRequests)
+}
+
+// GetLocalActiveRequests 获取本节点的活跃请求数
+func GetLocalActiveRequests() int64 {
 	return atomic.LoadInt64(&activeRequests)
 }
 
-// ShouldActivateGrayLogic 判断是否应该激活灰色逻辑
-func ShouldActivateGrayLogic() bool {
-	currentLoad := GetActiveRequests()
-	return currentLoad > grayLogicConcurrencyThreshold
+// SyncActiveRequests 定期向 Redis 上报本节点计数并刷新其他节点计数之和，租约为三个心跳周期
+func SyncActiveRequests(interval int) {
+	if interval <= 0 {
+		interval = 5
+	}
+	for {
+		heartbeatActiveRequests(interval * 3)
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
 }
 
-// GetGrayLogicConstants 获取灰色逻辑的配置常量
-func GetGrayLogicConstants() (maxTokens int, temperature float32, threshold int64) {
-	return grayLogicMaxTokens, grayLogicTemperature, grayLogicConcurrencyThreshold
-} 
\ No newline at end of file
+func heartbeatActiveRequests(leaseSeconds int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	others, err := activeRequestsHeartbeatScript.Run(ctx, RDB, []string{activeRequestsRedisKey, activeRequestsLeaseRedisKey},
+		activeRequestsNodeId, GetLocalActiveRequests(), leaseSeconds).Int64()
+	if err != nil {
+		// Redis 不可用时退回到本节点计数
+		atomic.StoreInt64(&clusterActiveRequests, 0)
+		SysError("failed to sync active requests: " + err.Error())
+		return
+	}
+	atomic.StoreInt64(&clusterActiveRequests, others)
+}
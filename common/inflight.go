@@ -0,0 +1,106 @@
+package common
+
+import (
+	"errors"
+	"sync"
+)
+
+// InflightKey 一个在途请求计入的模型、渠道、用户与令牌
+type InflightKey struct {
+	Model     string
+	ChannelId int
+	UserId    int
+	TokenId   int
+}
+
+var inflightLock sync.Mutex
+var inflightModels = make(map[string]int64)
+var inflightChannels = make(map[int]int64)
+var inflightUsers = make(map[int]int64)
+var inflightTokens = make(map[int]int64)
+
+func addInflightCount[K comparable](counts map[K]int64, key K, delta int64) {
+	counts[key] += delta
+	if counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
+
+func addInflight(key InflightKey, delta int64) {
+	addInflightCount(inflightModels, key.Model, delta)
+	addInflightCount(inflightChannels, key.ChannelId, delta)
+	addInflightCount(inflightUsers, key.UserId, delta)
+	addInflightCount(inflightTokens, key.TokenId, delta)
+}
+
+var (
+	ErrUserInflightLimit    = errors.New("当前用户或令牌的并发请求数已达上限")
+	ErrChannelInflightLimit = errors.New("当前渠道的并发请求数已达上限")
+)
+
+// InflightLimits 计入在途请求时检查的并发上限，<=0 表示不限制
+// 并发数只统计本节点的在途请求，多节点部署时实际的上限为节点数乘以设置的上限
+type InflightLimits struct {
+	User    int
+	Token   int
+	Channel int
+}
+
+// TryAddInflight 在用户、令牌与渠道的并发均未超过限制时计入一个在途请求
+// 检查与计入在同一把锁内完成，超过限制时返回对应的错误，且不会计入
+func TryAddInflight(key InflightKey, limits InflightLimits) error {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	if limits.User > 0 && inflightUsers[key.UserId] >= int64(limits.User) {
+		return ErrUserInflightLimit
+	}
+	if limits.Token > 0 && inflightTokens[key.TokenId] >= int64(limits.Token) {
+		return ErrUserInflightLimit
+	}
+	if limits.Channel > 0 && inflightChannels[key.ChannelId] >= int64(limits.Channel) {
+		return ErrChannelInflightLimit
+	}
+	addInflight(key, 1)
+	return nil
+}
+
+// MoveInflight 将一个在途请求从 from 改为计入 to，切换到的渠道并发已达 channelLimit 时返回错误，且保持计入 from
+func MoveInflight(from InflightKey, to InflightKey, channelLimit int) error {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	if channelLimit > 0 && from.ChannelId != to.ChannelId && inflightChannels[to.ChannelId] >= int64(channelLimit) {
+		return ErrChannelInflightLimit
+	}
+	addInflight(from, -1)
+	addInflight(to, 1)
+	return nil
+}
+
+// RemoveInflight 移除一个在途请求
+func RemoveInflight(key InflightKey) {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	addInflight(key, -1)
+}
+
+// GetChannelInflight 获取本节点上指定渠道的在途请求数
+func GetChannelInflight(channelId int) int64 {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	return inflightChannels[channelId]
+}
+
+func copyInflightCounts[K comparable](counts map[K]int64) map[K]int64 {
+	result := make(map[K]int64, len(counts))
+	for k, v := range counts {
+		result[k] = v
+	}
+	return result
+}
+
+// GetInflightSnapshot 获取本节点按模型、渠道、用户拆分的在途请求数快照
+func GetInflightSnapshot() (models map[string]int64, channels map[int]int64, users map[int]int64) {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	return copyInflightCounts(inflightModels), copyInflightCounts(inflightChannels), copyInflightCounts(inflightUsers)
+}
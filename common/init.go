@@ -23,6 +23,7 @@ func printHelp() {
 	fmt.Println("Copyright (C) 2023 JustSong. All rights reserved.")
 	fmt.Println("GitHub: https://github.com/songquanpeng/one-api")
 	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("       one-api simulate [--trace <requests.jsonl>] [--group <group>] [--concurrency <n>] [--latency <duration>] [--error-rate <rate>]")
 }
 
 func InitEnv() {
@@ -83,6 +84,7 @@ func InitEnv() {
 	SyncFrequency = GetEnvOrDefault("SYNC_FREQUENCY", 60)
 	BatchUpdateInterval = GetEnvOrDefault("BATCH_UPDATE_INTERVAL", 5)
 	RelayTimeout = GetEnvOrDefault("RELAY_TIMEOUT", 0)
+	ActiveRequestsHeartbeatInterval = GetEnvOrDefault("ACTIVE_REQUESTS_HEARTBEAT_INTERVAL", 5)
 
 	// Initialize string variables with GetEnvOrDefaultString
 	GeminiSafetySetting = GetEnvOrDefaultString("GEMINI_SAFETY_SETTING", "BLOCK_NONE")
@@ -51,6 +51,11 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 		opt(config)
 	}
 
+	overdraft := "0"
+	if config.Overdraft {
+		overdraft = "1"
+	}
+
 	// 执行限流
 	result, err := rl.client.EvalSha(
 		ctx,
@@ -59,6 +64,7 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 		config.Requested,
 		config.Rate,
 		config.Capacity,
+		overdraft,
 	).Int()
 
 	if err != nil {
@@ -72,6 +78,7 @@ type Config struct {
 	Capacity  int64
 	Rate      int64
 	Requested int64
+	Overdraft bool
 }
 
 type Option func(*Config)
@@ -87,3 +94,8 @@ func WithRate(r int64) Option {
 func WithRequested(n int64) Option {
 	return func(cfg *Config) { cfg.Requested = n }
 }
+
+// WithOverdraft 令牌不足时仍然扣减，使桶进入负值，直到补充回正前的请求都会被拒绝
+func WithOverdraft() Option {
+	return func(cfg *Config) { cfg.Overdraft = true }
+}
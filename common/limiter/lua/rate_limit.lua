@@ -1,13 +1,15 @@
 -- 令牌桶限流器
 -- KEYS[1]: 限流器唯一标识
--- ARGV[1]: 请求令牌数 (通常为1)
+-- ARGV[1]: 请求令牌数 (通常为1，负数表示归还令牌)
 -- ARGV[2]: 令牌生成速率 (每秒)
 -- ARGV[3]: 桶容量
+-- ARGV[4]: 是否允许透支 (1 表示令牌不足时仍扣减，桶可为负，用于请求结束后按实际用量扣减)
 
 local key = KEYS[1]
 local requested = tonumber(ARGV[1])
 local rate = tonumber(ARGV[2])
 local capacity = tonumber(ARGV[3])
+local overdraft = ARGV[4] == '1'
 
 -- 获取当前时间（Redis服务器时间）
 local now = redis.call('TIME')
@@ -33,8 +35,11 @@ end
 -- 判断是否允许请求
 local allowed = false
 if tokens >= requested then
-    tokens = tokens - requested
+    -- 归还令牌时不超过桶容量
+    tokens = math.min(capacity, tokens - requested)
     allowed = true
+elseif overdraft then
+    tokens = tokens - requested
 end
 
 ---- 更新桶状态并设置过期时间
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 渠道模型列表、能力与模型价格中的模型名可以写成模型通配符：
+//   - 包含 ^ $ ( ) [ ] { } | + \ 或 .* 时按正则表达式处理，例如 qwen/.*
+//   - 否则包含 * 时按通配符处理，* 匹配任意字符，例如 claude-3-5-*
+//
+// 两种写法都需要匹配完整的模型名；精确的模型名总是优先于模型通配符
+const modelRegexChars = `^$()[]{}|+\`
+
+// IsModelPattern 模型名是否为模型通配符
+func IsModelPattern(name string) bool {
+	return isModelRegex(name) || strings.Contains(name, "*")
+}
+
+func isModelRegex(name string) bool {
+	return strings.ContainsAny(name, modelRegexChars) || strings.Contains(name, ".*")
+}
+
+type ModelPattern struct {
+	Pattern string
+	prefix  string
+	re      *regexp.Regexp
+}
+
+func CompileModelPattern(pattern string) (*ModelPattern, error) {
+	expr := pattern
+	if !isModelRegex(pattern) {
+		parts := strings.Split(pattern, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		expr = strings.Join(parts, ".*")
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("模型通配符 %s 无效：%s", pattern, err.Error())
+	}
+	prefix, _ := re.LiteralPrefix()
+	return &ModelPattern{Pattern: pattern, prefix: prefix, re: re}, nil
+}
+
+func (pattern *ModelPattern) Match(name string) bool {
+	return strings.HasPrefix(name, pattern.prefix) && pattern.re.MatchString(name)
+}
+
+// ModelPatternIndex 按字面前缀的首字符索引模型通配符，匹配时只检查首字符相同或没有字面前缀的模型通配符
+type ModelPatternIndex struct {
+	buckets   map[byte][]*ModelPattern
+	noPrefix  []*ModelPattern
+	byPattern map[string]*ModelPattern
+}
+
+// NewModelPatternIndex 为 names 中的模型通配符建立索引，忽略精确的模型名与无效的模型通配符
+func NewModelPatternIndex(names []string) *ModelPatternIndex {
+	index := &ModelPatternIndex{buckets: make(map[byte][]*ModelPattern), byPattern: make(map[string]*ModelPattern)}
+	for _, name := range names {
+		if !IsModelPattern(name) || index.byPattern[name] != nil {
+			continue
+		}
+		pattern, err := CompileModelPattern(name)
+		if err != nil {
+			SysError(err.Error())
+			continue
+		}
+		index.byPattern[name] = pattern
+		if pattern.prefix == "" {
+			index.noPrefix = append(index.noPrefix, pattern)
+		} else {
+			index.buckets[pattern.prefix[0]] = append(index.buckets[pattern.prefix[0]], pattern)
+		}
+	}
+	return index
+}
+
+func (index *ModelPatternIndex) Len() int {
+	if index == nil {
+		return 0
+	}
+	return len(index.byPattern)
+}
+
+// Match 返回匹配模型名的全部模型通配符，字面前缀越长越靠前
+func (index *ModelPatternIndex) Match(name string) []string {
+	if index.Len() == 0 || name == "" {
+		return nil
+	}
+	var matched []*ModelPattern
+	for _, pattern := range index.buckets[name[0]] {
+		if pattern.Match(name) {
+			matched = append(matched, pattern)
+		}
+	}
+	for _, pattern := range index.noPrefix {
+		if pattern.Match(name) {
+			matched = append(matched, pattern)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if len(matched[i].prefix) != len(matched[j].prefix) {
+			return len(matched[i].prefix) > len(matched[j].prefix)
+		}
+		return matched[i].Pattern < matched[j].Pattern
+	})
+	patterns := make([]string, len(matched))
+	for i, pattern := range matched {
+		patterns[i] = pattern.Pattern
+	}
+	return patterns
+}
@@ -107,3 +107,9 @@ var ChannelBaseURLs = []string{
 	"https://api.klingai.com",                   //50
 	"https://visual.volcengineapi.com",          //51
 }
+
+// 多密钥渠道的密钥轮换方式，为空表示单密钥渠道
+const (
+	ChannelKeyRotationRoundRobin = "round_robin" // 按顺序轮流使用已启用的密钥
+	ChannelKeyRotationRandom     = "random"      // 随机使用一个已启用的密钥
+)
@@ -5,24 +5,49 @@ type ContextKey string
 const (
 	ContextKeyOriginalModel    ContextKey = "original_model"
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
+	ContextKeyInflight         ContextKey = "inflight"
+	ContextKeyStickyKey        ContextKey = "sticky_key"
+	ContextKeyStickyRoute      ContextKey = "sticky_route"
+	ContextKeyHedgeAttempt     ContextKey = "hedge_attempt"
+	ContextKeyHedgeUsage       ContextKey = "hedge_usage"
+	ContextKeyShadowSide       ContextKey = "shadow_side"
+
+	ContextKeyPromptAffinityChannelId ContextKey = "prompt_affinity_channel_id"
+	ContextKeyRouteConstraints        ContextKey = "route_constraints"
+
+	/* virtual policy related keys */
+	ContextKeyPolicyName        ContextKey = "policy_name"
+	ContextKeyPolicyTriedModels ContextKey = "policy_tried_models"
+	ContextKeyPolicyStrategy    ContextKey = "policy_strategy"
+
+	/* model fallback related keys */
+	ContextKeyFallbackFrom        ContextKey = "fallback_from"
+	ContextKeyFallbackTriedModels ContextKey = "fallback_tried_models"
 
 	/* token related keys */
-	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
-	ContextKeyTokenKey               ContextKey = "token_key"
-	ContextKeyTokenId                ContextKey = "token_id"
-	ContextKeyTokenGroup             ContextKey = "token_group"
-	ContextKeyTokenAllowIps          ContextKey = "allow_ips"
-	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
-	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
-	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+	ContextKeyTokenUnlimited           ContextKey = "token_unlimited_quota"
+	ContextKeyTokenKey                 ContextKey = "token_key"
+	ContextKeyTokenId                  ContextKey = "token_id"
+	ContextKeyTokenGroup               ContextKey = "token_group"
+	ContextKeyTokenAllowIps            ContextKey = "allow_ips"
+	ContextKeyTokenSpecificChannelId   ContextKey = "specific_channel_id"
+	ContextKeyTokenModelLimitEnabled   ContextKey = "token_model_limit_enabled"
+	ContextKeyTokenModelLimit          ContextKey = "token_model_limit"
+	ContextKeyTokenGrayLogicOptOut     ContextKey = "token_gray_logic_opt_out"
+	ContextKeyTokenMaxConcurrency      ContextKey = "token_max_concurrency"
+	ContextKeyTokenModelFallbacks      ContextKey = "token_model_fallbacks"
+	ContextKeyTokenRouteConstraintKeys ContextKey = "token_route_constraint_keys"
 
 	/* channel related keys */
 	ContextKeyBaseUrl        ContextKey = "base_url"
 	ContextKeyChannelType    ContextKey = "channel_type"
 	ContextKeyChannelId      ContextKey = "channel_id"
+	ContextKeyChannelKeyId   ContextKey = "channel_key_id"
 	ContextKeyChannelSetting ContextKey = "channel_setting"
 	ContextKeyParamOverride  ContextKey = "param_override"
 
+	ContextKeyChannelMaxConcurrency ContextKey = "channel_max_concurrency"
+
 	/* user related keys */
 	ContextKeyUserId      ContextKey = "id"
 	ContextKeyUserSetting ContextKey = "user_setting"
@@ -32,4 +57,6 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+
+	ContextKeyUserMaxConcurrency ContextKey = "user_max_concurrency"
 )
@@ -0,0 +1,16 @@
+package constant
+
+// 虚拟策略模型的成员选择策略
+const (
+	PolicyStrategyWeighted = "weighted" // 按成员权重随机选择
+	PolicyStrategyPriority = "priority" // 始终选择第一个成员
+	PolicyStrategyDegrade  = "degrade"  // 正常负载选择第一个成员，高负载时选择最后一个成员
+	PolicyStrategyCheapest = "cheapest" // 选择当前分组下有可用渠道且实际价格最低的成员
+)
+
+var PolicyStrategies = []string{
+	PolicyStrategyWeighted,
+	PolicyStrategyPriority,
+	PolicyStrategyDegrade,
+	PolicyStrategyCheapest,
+}
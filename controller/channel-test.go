@@ -19,6 +19,7 @@ import (
 	relaycommon "one-api/relay/common"
 	"one-api/relay/helper"
 	"one-api/service"
+	"one-api/setting/operation_setting"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,6 +31,12 @@ import (
 )
 
 func testChannel(channel *model.Channel, testModel string) (err error, openAIErrorWithStatusCode *dto.OpenAIErrorWithStatusCode) {
+	return testChannelWithRequest(channel, nil, testModel, nil)
+}
+
+// testChannelWithRequest 使用指定的密钥与对话请求测试渠道，probeRequest 为空或测试 Embedding 模型时使用默认的测试请求
+// 多密钥渠道未指定密钥时使用 model.GetTestChannelKey 选择的密钥，每次测试只发送一个密钥
+func testChannelWithRequest(channel *model.Channel, channelKey *model.ChannelKey, testModel string, probeRequest *dto.GeneralOpenAIRequest) (err error, openAIErrorWithStatusCode *dto.OpenAIErrorWithStatusCode) {
 	tik := time.Now()
 	if channel.Type == constant.ChannelTypeMidjourney {
 		return errors.New("midjourney channel test is not supported"), nil
@@ -79,20 +86,26 @@ func testChannel(channel *model.Channel, testModel string) (err error, openAIErr
 		}
 	}
 
+	if channelKey == nil && channel.IsMultiKey() {
+		channelKey, err = model.GetTestChannelKey(channel.Id)
+		if err != nil {
+			return err, nil
+		}
+	}
+
 	cache, err := model.GetUserCache(1)
 	if err != nil {
 		return err, nil
 	}
 	cache.WriteContext(c)
 
-	c.Request.Header.Set("Authorization", "Bearer "+channel.Key)
 	c.Request.Header.Set("Content-Type", "application/json")
 	c.Set("channel", channel.Type)
 	c.Set("base_url", channel.GetBaseURL())
 	group, _ := model.GetUserGroup(1, false)
 	c.Set("group", group)
 
-	middleware.SetupContextForSelectedChannel(c, channel, testModel)
+	middleware.SetupContextForChannelKey(c, channel, channelKey, testModel)
 
 	info := relaycommon.GenRelayInfo(c)
 
@@ -109,6 +122,10 @@ func testChannel(channel *model.Channel, testModel string) (err error, openAIErr
 	}
 
 	request := buildTestRequest(testModel)
+	if probeRequest != nil && requestPath == "/v1/chat/completions" {
+		request = probeRequest
+		request.Model = testModel
+	}
 	// 创建一个用于日志的 info 副本，移除 ApiKey
 	logInfo := *info
 	logInfo.ApiKey = ""
@@ -244,9 +261,20 @@ func TestChannel(c *gin.Context) {
 		})
 		return
 	}
+	var channelKey *model.ChannelKey
+	if keyId, _ := strconv.Atoi(c.Query("key_id")); keyId != 0 {
+		channelKey, err = model.GetChannelKeyById(channel.Id, keyId)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密钥不存在",
+			})
+			return
+		}
+	}
 	testModel := c.Query("model")
 	tik := time.Now()
-	err, _ = testChannel(channel, testModel)
+	err, _ = testChannelWithRequest(channel, channelKey, testModel, nil)
 	tok := time.Now()
 	milliseconds := tok.Sub(tik).Milliseconds()
 	go channel.UpdateResponseTime(milliseconds)
@@ -296,6 +324,10 @@ func testAllChannels(notify bool) error {
 		}()
 
 		for _, channel := range channels {
+			// 自动禁用的渠道由重新探测按各自的计划测试
+			if channel.Status == common.ChannelStatusAutoDisabled && operation_setting.GetChannelProbeSetting().Enabled {
+				continue
+			}
 			isChannelEnabled := channel.Status == common.ChannelStatusEnabled
 			tik := time.Now()
 			err, openaiWithStatusErr := testChannel(channel, "")
@@ -328,6 +360,10 @@ func testAllChannels(notify bool) error {
 
 			channel.UpdateResponseTime(milliseconds)
 			time.Sleep(common.RequestInterval)
+
+			if isChannelEnabled && channel.IsMultiKey() && !operation_setting.GetChannelProbeSetting().Enabled {
+				testAutoDisabledChannelKeys(channel, disableThreshold)
+			}
 		}
 
 		if notify {
@@ -337,6 +373,24 @@ func testAllChannels(notify bool) error {
 	return nil
 }
 
+// testAutoDisabledChannelKeys 单独测试多密钥渠道中被自动禁用的密钥，测试通过的密钥重新启用
+func testAutoDisabledChannelKeys(channel *model.Channel, disableThreshold int64) {
+	keys, err := model.GetAutoDisabledChannelKeys(channel.Id)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get auto disabled keys of channel #%d: %s", channel.Id, err.Error()))
+		return
+	}
+	for _, key := range keys {
+		tik := time.Now()
+		err, openaiWithStatusErr := testChannelWithRequest(channel, key, "", nil)
+		milliseconds := time.Since(tik).Milliseconds()
+		if milliseconds <= disableThreshold && service.ShouldEnableChannel(err, openaiWithStatusErr, key.Status) {
+			service.EnableChannelKey(channel.Id, key.Id, channel.Name)
+		}
+		time.Sleep(common.RequestInterval)
+	}
+}
+
 func TestAllChannels(c *gin.Context) {
 	err := testAllChannels(true)
 	if err != nil {
@@ -149,6 +149,7 @@ func GetAllChannels(c *gin.Context) {
 		typeCounts[r.Type] = r.Count
 	}
 
+	model.FillChannelCircuitBreakers(channelData)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -342,6 +343,7 @@ func SearchChannels(c *gin.Context) {
 	}
 
 	pagedData := channelData[startIdx:endIdx]
+	model.FillChannelCircuitBreakers(pagedData)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -422,6 +424,10 @@ func AddChannel(c *gin.Context) {
 		}
 		keys = []string{channel.Key}
 	}
+	if channel.IsMultiKey() {
+		// 多密钥渠道的所有密钥保存在同一个渠道中
+		keys = []string{channel.Key}
+	}
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
 		if key == "" {
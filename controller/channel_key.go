@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelKeys 返回多密钥渠道中各密钥的状态与已用额度，密钥经过脱敏
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	keys, err := model.GetChannelKeys(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, key := range keys {
+		key.Key = key.MaskKey()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    keys,
+	})
+}
+
+type UpdateChannelKeyStatusRequest struct {
+	KeyId  int `json:"key_id"`
+	Status int `json:"status"`
+}
+
+// UpdateChannelKeyStatus 手动启用或禁用多密钥渠道中的单个密钥
+func UpdateChannelKeyStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req := UpdateChannelKeyStatusRequest{}
+	err = c.ShouldBindJSON(&req)
+	if err != nil || req.KeyId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	if req.Status != common.ChannelStatusEnabled && req.Status != common.ChannelStatusManuallyDisabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持的密钥状态",
+		})
+		return
+	}
+	keys, err := model.GetChannelKeys(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	found := false
+	for _, key := range keys {
+		if key.Id == req.KeyId {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "密钥不存在",
+		})
+		return
+	}
+	if model.UpdateChannelKeyStatus(req.KeyId, req.Status, "") && req.Status == common.ChannelStatusEnabled {
+		service.EnableChannelOfKey(id)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
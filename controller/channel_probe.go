@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"time"
+)
+
+// 检查是否有渠道到达探测时间的间隔
+const channelProbeCheckInterval = 10 * time.Second
+
+// AutomaticallyProbeChannels 按各自的探测计划重新探测自动禁用的渠道，连续成功足够次数后重新启用
+// 多密钥渠道逐个探测被自动禁用的密钥，密钥全部被禁用的渠道在有密钥通过探测后一并启用
+func AutomaticallyProbeChannels() {
+	for {
+		time.Sleep(channelProbeCheckInterval)
+		setting := operation_setting.GetChannelProbeSetting()
+		if !setting.Enabled || !common.AutomaticEnableChannelEnabled {
+			continue
+		}
+		channels, err := model.GetAutoDisabledChannels()
+		if err != nil {
+			common.SysError("failed to get auto disabled channels: " + err.Error())
+			continue
+		}
+		for _, channel := range channels {
+			if channel.IsMultiKey() && model.CountEnabledChannelKeys(channel.Id) == 0 {
+				continue
+			}
+			state := channel.PrepareProbeState(setting)
+			if state.NextProbeAt > common.GetTimestamp() {
+				continue
+			}
+			probeChannel(channel, state, setting)
+			time.Sleep(common.RequestInterval)
+		}
+		channels, err = model.GetAutoDisabledKeyChannels()
+		if err != nil {
+			common.SysError("failed to get channels with auto disabled keys: " + err.Error())
+			continue
+		}
+		for _, channel := range channels {
+			probeChannelKeys(channel, setting)
+		}
+	}
+}
+
+func buildProbeRequest(setting *operation_setting.ChannelProbeSetting) *dto.GeneralOpenAIRequest {
+	if setting.ProbeRequest == "" {
+		return nil
+	}
+	request := &dto.GeneralOpenAIRequest{}
+	if err := json.Unmarshal([]byte(setting.ProbeRequest), request); err != nil {
+		common.SysError("failed to unmarshal channel probe request: " + err.Error())
+		return nil
+	}
+	return request
+}
+
+// runChannelProbe 使用探测请求测试渠道，channelKey 不为 nil 时测试多密钥渠道中的指定密钥
+func runChannelProbe(channel *model.Channel, channelKey *model.ChannelKey, setting *operation_setting.ChannelProbeSetting) (model.ChannelProbeRecord, error) {
+	tik := time.Now()
+	err, openaiWithStatusErr := testChannelWithRequest(channel, channelKey, "", buildProbeRequest(setting))
+	milliseconds := time.Since(tik).Milliseconds()
+	if openaiWithStatusErr != nil {
+		oaiErr := openaiWithStatusErr.Error
+		err = errors.New(fmt.Sprintf("type %s, httpCode %d, code %v, message %s", oaiErr.Type, openaiWithStatusErr.StatusCode, oaiErr.Code, oaiErr.Message))
+	}
+	disableThreshold := int64(common.ChannelDisableThreshold * 1000)
+	if err == nil && disableThreshold > 0 && milliseconds > disableThreshold {
+		err = errors.New(fmt.Sprintf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0))
+	}
+
+	record := model.ChannelProbeRecord{Time: common.GetTimestamp(), Success: err == nil, LatencyMs: milliseconds}
+	if err != nil {
+		record.Message = err.Error()
+	}
+	return record, err
+}
+
+func probeChannel(channel *model.Channel, state *model.ChannelProbeState, setting *operation_setting.ChannelProbeSetting) {
+	record, probeErr := runChannelProbe(channel, nil, setting)
+	passed := state.Record(record, setting)
+	status, err := channel.SaveProbeState(state)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to save probe state of channel #%d: %s", channel.Id, err.Error()))
+		return
+	}
+	common.SysLog(fmt.Sprintf("probed channel #%d, success: %t, consecutive successes: %d, next probe in %ds",
+		channel.Id, record.Success, state.ConsecutiveSuccesses, state.IntervalSeconds))
+	// 以保存时的状态为准，探测期间被手动启用或禁用的渠道不再处理
+	if passed && service.ShouldEnableChannel(probeErr, nil, status) {
+		service.EnableChannel(channel.Id, channel.Name)
+	}
+}
+
+func probeChannelKeys(channel *model.Channel, setting *operation_setting.ChannelProbeSetting) {
+	keys, err := model.GetAutoDisabledChannelKeys(channel.Id)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get auto disabled keys of channel #%d: %s", channel.Id, err.Error()))
+		return
+	}
+	for _, key := range keys {
+		state := key.PrepareProbeState(setting)
+		if state.NextProbeAt > common.GetTimestamp() {
+			continue
+		}
+		probeChannelKey(channel, key, state, setting)
+		time.Sleep(common.RequestInterval)
+	}
+}
+
+func probeChannelKey(channel *model.Channel, key *model.ChannelKey, state *model.ChannelProbeState, setting *operation_setting.ChannelProbeSetting) {
+	record, probeErr := runChannelProbe(channel, key, setting)
+	passed := state.Record(record, setting)
+	status, err := key.SaveProbeState(state)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to save probe state of channel #%d key #%d: %s", channel.Id, key.Id, err.Error()))
+		return
+	}
+	common.SysLog(fmt.Sprintf("probed channel #%d key #%d, success: %t, consecutive successes: %d, next probe in %ds",
+		channel.Id, key.Id, record.Success, state.ConsecutiveSuccesses, state.IntervalSeconds))
+	if passed && service.ShouldEnableChannel(probeErr, nil, status) {
+		service.EnableChannelKey(channel.Id, key.Id, channel.Name)
+	}
+}
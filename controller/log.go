@@ -5,6 +5,7 @@ import (
 	"one-api/common"
 	"one-api/model"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -163,6 +164,84 @@ func GetLogsStat(c *gin.Context) {
 	return
 }
 
+// GetPromptAffinityStats 统计提示词亲和路由的缓存命中率，默认统计最近 24 小时
+func GetPromptAffinityStats(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if startTimestamp == 0 {
+		startTimestamp = time.Now().Add(-24 * time.Hour).Unix()
+	}
+	stats, err := model.GetPromptAffinityStats(startTimestamp, endTimestamp, c.Query("model_name"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
+// GetShadowLogs 分页列出影子流量的对比记录，包括两侧的输出内容
+func GetShadowLogs(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	shadowChannelId, _ := strconv.Atoi(c.Query("channel"))
+	logs, total, err := model.GetShadowLogs(startTimestamp, endTimestamp, shadowChannelId, c.Query("model_name"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     logs,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetShadowStats 按影子渠道与模型对比影子渠道与主渠道在同一批请求上的表现，默认统计最近 24 小时
+func GetShadowStats(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if startTimestamp == 0 {
+		startTimestamp = time.Now().Add(-24 * time.Hour).Unix()
+	}
+	shadowChannelId, _ := strconv.Atoi(c.Query("channel"))
+	stats, err := model.GetShadowComparisons(startTimestamp, endTimestamp, shadowChannelId, c.Query("model_name"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
 func GetLogsSelfStat(c *gin.Context) {
 	username := c.GetString("username")
 	logType, _ := strconv.Atoi(c.Query("type"))
@@ -8,6 +8,7 @@ import (
 	"one-api/constant"
 	"one-api/middleware"
 	"one-api/model"
+	"one-api/service"
 	"one-api/setting"
 	"one-api/setting/console_setting"
 	"one-api/setting/operation_setting"
@@ -36,6 +37,15 @@ func TestStatus(c *gin.Context) {
 	return
 }
 
+// GetInflightRequests 获取在途请求统计，按模型、渠道与用户拆分
+func GetInflightRequests(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetInflightStats(),
+	})
+}
+
 func GetStatus(c *gin.Context) {
 
 	cs := console_setting.GetConsoleSetting()
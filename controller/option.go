@@ -112,6 +112,42 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "PolicyPrice":
+		err = ratio_setting.CheckPolicyPrice(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "TagSchedules":
+		err = setting.CheckTagSchedules(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RetryPolicy":
+		err = setting.CheckRetryPolicy(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelFallbackChains":
+		err = setting.CheckModelFallbackChains(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	case "ModelRequestRateLimitGroup":
 		err = setting.CheckModelRequestRateLimitGroup(option.Value)
 		if err != nil {
@@ -64,7 +64,11 @@ func Playground(c *gin.Context) {
 		openaiErr = service.OpenAIErrorWrapperLocal(errors.New(message), "get_playground_channel_failed", http.StatusInternalServerError)
 		return
 	}
-	middleware.SetupContextForSelectedChannel(c, channel, playgroundRequest.Model)
+	if err = middleware.SetupContextForSelectedChannel(c, channel, playgroundRequest.Model); err != nil {
+		model.ReleaseChannelRateLimit(channel, playgroundRequest.Model)
+		openaiErr = service.OpenAIErrorWrapperLocal(err, "get_playground_channel_failed", http.StatusServiceUnavailable)
+		return
+	}
 	common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
 
 	// Write user context to ensure acceptUnsetRatio is available
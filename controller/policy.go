@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+func GetAllPolicies(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize < 1 {
+		pageSize = common.ItemsPerPage
+	}
+	policies, total, err := model.GetAllPolicies((p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     policies,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func SearchPolicies(c *gin.Context) {
+	keyword := c.Query("keyword")
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize < 1 {
+		pageSize = common.ItemsPerPage
+	}
+	policies, total, err := model.SearchPolicies(keyword, (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     policies,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func GetPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	policy, err := model.GetPolicyById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    policy,
+	})
+}
+
+func validatePolicy(policy *model.Policy) string {
+	if len(policy.Name) == 0 || len(policy.Name) > 255 {
+		return "策略名称长度必须在1-255之间"
+	}
+	if policy.Strategy == "" {
+		policy.Strategy = constant.PolicyStrategyWeighted
+	}
+	if !lo.Contains(constant.PolicyStrategies, policy.Strategy) {
+		return "不支持的策略类型：" + policy.Strategy
+	}
+	if err := policy.ValidateMembers(); err != nil {
+		return err.Error()
+	}
+	if model.IsPolicyNameExists(policy.Name, policy.Id) {
+		return "策略名称已存在"
+	}
+	return ""
+}
+
+func AddPolicy(c *gin.Context) {
+	policy := model.Policy{}
+	err := c.ShouldBindJSON(&policy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	policy.Id = 0
+	if message := validatePolicy(&policy); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	if policy.Status == 0 {
+		policy.Status = common.PolicyStatusEnabled
+	}
+	err = policy.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitPolicyCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    policy,
+	})
+}
+
+func UpdatePolicy(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	policy := model.Policy{}
+	err := c.ShouldBindJSON(&policy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPolicy, err := model.GetPolicyById(policy.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if statusOnly == "" {
+		if message := validatePolicy(&policy); message != "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": message,
+			})
+			return
+		}
+		// If you add more fields, please also update policy.Update()
+		cleanPolicy.Name = policy.Name
+		cleanPolicy.Strategy = policy.Strategy
+		cleanPolicy.Members = policy.Members
+		cleanPolicy.Description = policy.Description
+	}
+	if policy.Status != 0 {
+		cleanPolicy.Status = policy.Status
+	}
+	err = cleanPolicy.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitPolicyCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPolicy,
+	})
+}
+
+func DeletePolicy(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeletePolicyById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitPolicyCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
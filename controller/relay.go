@@ -17,7 +17,9 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
+	"one-api/setting"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -46,7 +48,7 @@ func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 		err = relay.TextHelper(c)
 	}
 
-	if constant2.ErrorLogEnabled && err != nil {
+	if constant2.ErrorLogEnabled && err != nil && !service.IsShadowRequest(c) {
 		// 保存错误日志到mysql中
 		userId := c.GetInt("id")
 		tokenName := c.GetString("token_name")
@@ -75,23 +77,62 @@ func Relay(c *gin.Context) {
 	originalModel := c.GetString("original_model")
 	var openaiErr *dto.OpenAIErrorWithStatusCode
 
+	// 按比例将请求镜像到影子渠道，主渠道一侧的结果在请求结束时记录
+	mirror := startShadowMirror(c, group, originalModel, func(c *gin.Context, channel *model.Channel) (int, string) {
+		if err := relayRequest(c, relayMode, channel); err != nil {
+			return err.StatusCode, err.Error.Message
+		}
+		return 0, ""
+	})
+	if mirror != nil {
+		defer func() {
+			if openaiErr != nil {
+				finishShadowPrimary(c, mirror, openaiErr.StatusCode, openaiErr.Error.Message)
+			} else {
+				finishShadowPrimary(c, mirror, 0, "")
+			}
+		}()
+	}
+
+	tier := 0 // 重试时选择渠道的优先级序号，由重试规则决定是否切换
 	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
+		channel, err := getChannel(c, group, originalModel, i, tier)
 		if err != nil {
 			common.LogError(c, err.Error())
 			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			if failoverModel(c, group, &originalModel) {
+				// 从新的模型重新开始计数
+				i, tier = -1, 0
+				continue
+			}
 			break
 		}
 
-		openaiErr = relayRequest(c, relayMode, channel)
+		if i == 0 && service.ShouldHedge(c, group) {
+			// 对冲请求的渠道结果由各个尝试自行记录
+			channel, openaiErr = relayWithHedge(c, relayMode, channel, group, originalModel)
+		} else {
+			openaiErr = relayRequest(c, relayMode, channel)
+			service.RecordChannelRelayResult(c, channel.Id, originalModel, openaiErr)
+			if openaiErr != nil {
+				go processChannelError(c, channel.Id, common.GetContextKeyInt(c, constant.ContextKeyChannelKeyId), channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+			}
+		}
 
 		if openaiErr == nil {
 			return // 成功处理请求，直接返回
 		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
-
-		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
+		retryRule := getRetryRule(c, openaiErr)
+		if !retryRule.Retry || i >= common.RetryTimes {
+			// 当前模型的重试次数已用尽，对可重试的错误切换到虚拟策略的下一个成员模型或回退链上的模型
+			if retryRule.Retry && failoverModel(c, group, &originalModel) {
+				i, tier = -1, 0
+				continue
+			}
+			break
+		}
+		if !prepareRetry(c, retryRule, i+1, &tier) {
 			break
 		}
 	}
@@ -139,23 +180,39 @@ func WssRelay(c *gin.Context) {
 	originalModel := c.GetString("original_model")
 	var openaiErr *dto.OpenAIErrorWithStatusCode
 
+	tier := 0 // 重试时选择渠道的优先级序号，由重试规则决定是否切换
 	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
+		channel, err := getChannel(c, group, originalModel, i, tier)
 		if err != nil {
 			common.LogError(c, err.Error())
 			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			if failoverModel(c, group, &originalModel) {
+				// 从新的模型重新开始计数
+				i, tier = -1, 0
+				continue
+			}
 			break
 		}
 
 		openaiErr = wssRequest(c, ws, relayMode, channel)
+		service.RecordChannelRelayResult(c, channel.Id, originalModel, openaiErr)
 
 		if openaiErr == nil {
 			return // 成功处理请求，直接返回
 		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+		go processChannelError(c, channel.Id, common.GetContextKeyInt(c, constant.ContextKeyChannelKeyId), channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
 
-		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
+		retryRule := getRetryRule(c, openaiErr)
+		if !retryRule.Retry || i >= common.RetryTimes {
+			// 当前模型的重试次数已用尽，对可重试的错误切换到虚拟策略的下一个成员模型或回退链上的模型
+			if retryRule.Retry && failoverModel(c, group, &originalModel) {
+				i, tier = -1, 0
+				continue
+			}
+			break
+		}
+		if !prepareRetry(c, retryRule, i+1, &tier) {
 			break
 		}
 	}
@@ -181,25 +238,58 @@ func RelayClaude(c *gin.Context) {
 	originalModel := c.GetString("original_model")
 	var claudeErr *dto.ClaudeErrorWithStatusCode
 
+	mirror := startShadowMirror(c, group, originalModel, func(c *gin.Context, channel *model.Channel) (int, string) {
+		if err := claudeRequest(c, channel); err != nil {
+			return err.StatusCode, err.Error.Message
+		}
+		return 0, ""
+	})
+	if mirror != nil {
+		defer func() {
+			if claudeErr != nil {
+				finishShadowPrimary(c, mirror, claudeErr.StatusCode, claudeErr.Error.Message)
+			} else {
+				finishShadowPrimary(c, mirror, 0, "")
+			}
+		}()
+	}
+
+	tier := 0 // 重试时选择渠道的优先级序号，由重试规则决定是否切换
 	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
+		channel, err := getChannel(c, group, originalModel, i, tier)
 		if err != nil {
 			common.LogError(c, err.Error())
 			claudeErr = service.ClaudeErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			if failoverModel(c, group, &originalModel) {
+				// 从新的模型重新开始计数
+				i, tier = -1, 0
+				continue
+			}
 			break
 		}
 
 		claudeErr = claudeRequest(c, channel)
 
 		if claudeErr == nil {
+			service.RecordChannelRelayResult(c, channel.Id, originalModel, nil)
 			return // 成功处理请求，直接返回
 		}
 
 		openaiErr := service.ClaudeErrorToOpenAIError(claudeErr)
+		service.RecordChannelRelayResult(c, channel.Id, originalModel, openaiErr)
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+		go processChannelError(c, channel.Id, common.GetContextKeyInt(c, constant.ContextKeyChannelKeyId), channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
 
-		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
+		retryRule := getRetryRule(c, openaiErr)
+		if !retryRule.Retry || i >= common.RetryTimes {
+			// 当前模型的重试次数已用尽，对可重试的错误切换到虚拟策略的下一个成员模型或回退链上的模型
+			if retryRule.Retry && failoverModel(c, group, &originalModel) {
+				i, tier = -1, 0
+				continue
+			}
+			break
+		}
+		if !prepareRetry(c, retryRule, i+1, &tier) {
 			break
 		}
 	}
@@ -245,7 +335,7 @@ func addUsedChannel(c *gin.Context, channelId int) {
 	c.Set("use_channel", useChannel)
 }
 
-func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, error) {
+func getChannel(c *gin.Context, group, originalModel string, retryCount int, tier int) (*model.Channel, error) {
 	if retryCount == 0 {
 		autoBan := c.GetBool("auto_ban")
 		autoBanInt := 1
@@ -259,63 +349,87 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, _, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	channel, _, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, tier)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("获取重试渠道失败: %s", err.Error()))
 	}
-	middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+	if err = middleware.SetupContextForSelectedChannel(c, channel, originalModel); err != nil {
+		model.ReleaseChannelRateLimit(channel, originalModel)
+		return nil, errors.New(fmt.Sprintf("获取重试渠道失败: %s", err.Error()))
+	}
 	return channel, nil
 }
 
-func shouldRetry(c *gin.Context, openaiErr *dto.OpenAIErrorWithStatusCode, retryTimes int) bool {
-	if openaiErr == nil {
-		return false
+// failoverModel 切换到虚拟策略的下一个成员模型，成员模型均不可用时按回退链切换模型，并将选中的渠道写入上下文
+func failoverModel(c *gin.Context, group string, originalModel *string) bool {
+	channel, nextModel, _, ok := middleware.SelectNextPolicyMemberChannel(c, group)
+	if !ok {
+		channel, nextModel, ok = middleware.SelectNextFallbackModelChannel(c, group, *originalModel)
 	}
-	if openaiErr.LocalError {
+	if !ok {
 		return false
 	}
-	if retryTimes <= 0 {
+	if err := middleware.SetupContextForSelectedChannel(c, channel, nextModel); err != nil {
+		model.ReleaseChannelRateLimit(channel, nextModel)
+		common.LogError(c, err.Error())
 		return false
 	}
-	if _, ok := c.Get("specific_channel_id"); ok {
-		return false
+	*originalModel = nextModel
+	return true
+}
+
+// getRetryRule 按重试策略返回错误匹配的规则，本地错误与指定渠道的请求不重试
+func getRetryRule(c *gin.Context, openaiErr *dto.OpenAIErrorWithStatusCode) setting.RetryRule {
+	if openaiErr == nil || openaiErr.LocalError {
+		return setting.RetryRule{}
 	}
-	if openaiErr.StatusCode == http.StatusTooManyRequests {
-		return true
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return setting.RetryRule{}
+	}
+	errorCode := ""
+	if openaiErr.Error.Code != nil {
+		errorCode = fmt.Sprint(openaiErr.Error.Code)
+	}
+	return setting.GetRetryRule(&setting.RetryError{
+		StatusCode:  openaiErr.StatusCode,
+		Type:        openaiErr.Error.Type,
+		Code:        errorCode,
+		Message:     openaiErr.Error.Message,
+		ChannelType: c.GetInt("channel_type"),
+	})
+}
+
+// prepareRetry 按重试规则确定下一次选择渠道的优先级并等待退避时间，等待期间客户端断开时返回 false
+func prepareRetry(c *gin.Context, retryRule setting.RetryRule, attempt int, tier *int) bool {
+	if retryRule.SwitchTier {
+		*tier++
 	}
-	if openaiErr.StatusCode == 307 {
+	backoff := retryRule.GetBackoff(attempt)
+	if backoff <= 0 {
 		return true
 	}
-	if openaiErr.StatusCode/100 == 5 {
-		// 超时不重试
-		if openaiErr.StatusCode == 504 || openaiErr.StatusCode == 524 {
-			return false
-		}
+	common.LogInfo(c, fmt.Sprintf("retry #%d after %s", attempt, backoff))
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
 		return true
-	}
-	if openaiErr.StatusCode == http.StatusBadRequest {
-		channelType := c.GetInt("channel_type")
-		if channelType == constant.ChannelTypeAnthropic {
-			return true
-		}
-		return false
-	}
-	if openaiErr.StatusCode == 408 {
-		// azure处理超时不重试
-		return false
-	}
-	if openaiErr.StatusCode/100 == 2 {
+	case <-c.Request.Context().Done():
 		return false
 	}
-	return true
 }
 
-func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, autoBan bool, err *dto.OpenAIErrorWithStatusCode) {
+func processChannelError(c *gin.Context, channelId int, channelKeyId int, channelType int, channelName string, autoBan bool, err *dto.OpenAIErrorWithStatusCode) {
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
 	common.LogError(c, fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelId, err.StatusCode, err.Error.Message))
 	if service.ShouldDisableChannel(channelType, err) && autoBan {
-		service.DisableChannel(channelId, channelName, err.Error.Message)
+		if channelKeyId != 0 {
+			// 多密钥渠道只禁用出错的密钥
+			service.DisableChannelKey(channelId, channelKeyId, channelName, err.Error.Message)
+		} else {
+			service.DisableChannel(channelId, channelName, err.Error.Message)
+		}
 	}
 }
 
@@ -398,7 +512,11 @@ func RelayTask(c *gin.Context) {
 		useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
 		c.Set("use_channel", useChannel)
 		common.LogInfo(c, fmt.Sprintf("using channel #%d to retry (remain times %d)", channel.Id, i))
-		middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+		if err = middleware.SetupContextForSelectedChannel(c, channel, originalModel); err != nil {
+			model.ReleaseChannelRateLimit(channel, originalModel)
+			common.LogError(c, err.Error())
+			break
+		}
 
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
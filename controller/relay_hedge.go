@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"slices"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+var errHedgeLost = errors.New("hedged request lost the race")
+
+// hedgeResponseWriter 对冲尝试使用的响应写入器，胜出前响应头只写入自己的副本，首次写入时参与胜出竞争
+type hedgeResponseWriter struct {
+	gin.ResponseWriter
+	attempt   *service.HedgeAttempt
+	header    http.Header
+	committed bool
+}
+
+func (w *hedgeResponseWriter) Header() http.Header {
+	if w.committed {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeResponseWriter) commit() bool {
+	if w.committed {
+		return true
+	}
+	if !w.attempt.Claim() {
+		return false
+	}
+	for key, values := range w.header {
+		w.ResponseWriter.Header()[key] = values
+	}
+	w.committed = true
+	return true
+}
+
+func (w *hedgeResponseWriter) WriteHeader(code int) {
+	if w.commit() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *hedgeResponseWriter) WriteHeaderNow() {
+	if w.commit() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeResponseWriter) Write(data []byte) (int, error) {
+	if !w.commit() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeResponseWriter) WriteString(s string) (int, error) {
+	if !w.commit() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeResponseWriter) Flush() {
+	if w.committed {
+		w.ResponseWriter.Flush()
+	}
+}
+
+type hedgeResult struct {
+	c       *gin.Context
+	channel *model.Channel
+	attempt *service.HedgeAttempt
+	err     *dto.OpenAIErrorWithStatusCode
+}
+
+// relayWithHedge 首个渠道在设定时间内没有响应时，向同一模型的另一个渠道发出对冲请求
+// 两个请求在各自的 Context 副本中执行，并各自记录渠道结果；返回被采用的渠道及其错误，采用的上下文会写回 c
+func relayWithHedge(c *gin.Context, relayMode int, channel *model.Channel, group string, originalModel string) (*model.Channel, *dto.OpenAIErrorWithStatusCode) {
+	race := service.NewHedgeRace()
+	results := make(chan hedgeResult, 2)
+	startHedgeAttempt(c, race, 1, relayMode, channel, originalModel, results)
+
+	delay := time.Duration(operation_setting.GetHedgeSetting().DelayMs) * time.Millisecond
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return adoptHedgeResult(c, result)
+	case <-timer.C:
+	}
+	if race.Winner() != 0 {
+		// 首个渠道已经开始向客户端写出响应
+		return adoptHedgeResult(c, <-results)
+	}
+	hedgeChannel := selectHedgeChannel(c, group, originalModel, channel.Id)
+	if hedgeChannel == nil {
+		return adoptHedgeResult(c, <-results)
+	}
+	common.LogInfo(c, fmt.Sprintf("channel #%d has no response after %s, hedging to channel #%d", channel.Id, delay, hedgeChannel.Id))
+	startHedgeAttempt(c, race, 2, relayMode, hedgeChannel, originalModel, results)
+
+	var failed []hedgeResult
+	for len(failed) < 2 {
+		result := <-results
+		if result.err == nil || result.attempt.Id == race.Winner() {
+			return adoptHedgeResult(c, result)
+		}
+		failed = append(failed, result)
+	}
+	// 两个请求都失败时返回首个渠道的错误
+	if failed[0].attempt.Id != 1 {
+		return adoptHedgeResult(c, failed[1])
+	}
+	return adoptHedgeResult(c, failed[0])
+}
+
+func startHedgeAttempt(c *gin.Context, race *service.HedgeRace, id int32, relayMode int, channel *model.Channel, originalModel string, results chan<- hedgeResult) {
+	attempt := race.NewAttempt(c.Request.Context(), id)
+	cp := c.Copy()
+	cp.Request = c.Request.Clone(attempt.Context)
+	cp.Writer = &hedgeResponseWriter{ResponseWriter: c.Writer, attempt: attempt, header: c.Writer.Header().Clone()}
+	cp.Set("use_channel", slices.Clone(c.GetStringSlice("use_channel")))
+	common.SetContextKey(cp, constant.ContextKeyHedgeAttempt, attempt)
+	if id != 1 {
+		// 对冲渠道不单独计入在途请求，胜出后再将在途请求转移到该渠道
+		cp.Set(string(constant.ContextKeyInflight), nil)
+		if err := middleware.SetupContextForSelectedChannel(cp, channel, originalModel); err != nil {
+			model.ReleaseChannelRateLimit(channel, originalModel)
+			attempt.Finish()
+			results <- hedgeResult{c: cp, channel: channel, attempt: attempt, err: service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusServiceUnavailable)}
+			return
+		}
+	}
+	gopool.Go(func() {
+		defer attempt.Finish()
+		err := relayRequest(cp, relayMode, channel)
+		if attempt.Lost() {
+			service.RecordHedgeLoserLog(cp, channel.Id, originalModel)
+		} else {
+			service.RecordChannelRelayResult(cp, channel.Id, originalModel, err)
+			if err != nil {
+				processChannelError(cp, channel.Id, common.GetContextKeyInt(cp, constant.ContextKeyChannelKeyId), channel.Type, channel.Name, channel.GetAutoBan(), err)
+			}
+		}
+		results <- hedgeResult{c: cp, channel: channel, attempt: attempt, err: err}
+	})
+}
+
+func selectHedgeChannel(c *gin.Context, group string, modelName string, excludeChannelId int) *model.Channel {
+	for i := 0; i < 3; i++ {
+		channel, _, err := model.CacheGetRandomSatisfiedChannel(c, group, modelName, 0)
+		if err != nil {
+			return nil
+		}
+		if channel.Id != excludeChannelId {
+			return channel
+		}
+		// 选中了首个渠道本身，归还选择时占用的额度后重新选择
+		model.ReleaseChannelRateLimit(channel, modelName)
+	}
+	return nil
+}
+
+// adoptHedgeResult 将被采用的尝试的上下文写回 c，供后续重试、日志与粘性路由使用
+func adoptHedgeResult(c *gin.Context, result hedgeResult) (*model.Channel, *dto.OpenAIErrorWithStatusCode) {
+	for key, value := range result.c.Keys {
+		if key == string(constant.ContextKeyInflight) || key == string(constant.ContextKeyHedgeAttempt) {
+			continue
+		}
+		c.Set(key, value)
+	}
+	// 胜出的请求已经发出，在途请求直接转移到其渠道，不再检查渠道的并发上限
+	common.SetContextKey(c, constant.ContextKeyChannelMaxConcurrency, 0)
+	_ = service.UpdateInflight(c)
+	common.SetContextKey(c, constant.ContextKeyChannelMaxConcurrency, result.channel.GetMaxConcurrency())
+	return result.channel, result.err
+}
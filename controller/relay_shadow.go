@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// shadowResponseWriter 影子请求的响应写入器，响应不返回给客户端，只记录写出的内容
+type shadowResponseWriter struct {
+	side   *service.ShadowSide
+	header http.Header
+	status int
+	size   int
+}
+
+func newShadowResponseWriter(side *service.ShadowSide) *shadowResponseWriter {
+	return &shadowResponseWriter{side: side, header: make(http.Header), status: http.StatusOK, size: -1}
+}
+
+func (w *shadowResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *shadowResponseWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *shadowResponseWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *shadowResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.side.Capture(data)
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *shadowResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *shadowResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *shadowResponseWriter) Size() int {
+	return w.size
+}
+
+func (w *shadowResponseWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *shadowResponseWriter) Flush() {
+	w.WriteHeaderNow()
+}
+
+func (w *shadowResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("shadow response writer does not support hijacking")
+}
+
+func (w *shadowResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *shadowResponseWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// shadowCaptureWriter 被镜像请求的主渠道响应写入器，写给客户端的同时记录写出的内容
+type shadowCaptureWriter struct {
+	gin.ResponseWriter
+	side *service.ShadowSide
+}
+
+func (w *shadowCaptureWriter) Write(data []byte) (int, error) {
+	w.side.Capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *shadowCaptureWriter) WriteString(s string) (int, error) {
+	w.side.Capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// startShadowMirror 按影子流量规则抽样当前请求，命中时在独立的 Context 副本中向影子渠道发出同样的请求
+// 影子请求不受客户端断开影响，由 relayShadow 执行并返回状态码与错误信息；未命中、令牌不允许该模型或影子渠道不满足路由约束时返回 nil
+func startShadowMirror(c *gin.Context, group string, originalModel string, relayShadow func(c *gin.Context, channel *model.Channel) (int, string)) *service.ShadowMirror {
+	if !middleware.IsTokenModelAllowed(c, originalModel) {
+		return nil
+	}
+	mirror := service.NewShadowMirror(c, group, originalModel)
+	if mirror == nil {
+		return nil
+	}
+	channel, err := model.CacheGetChannel(mirror.Rule.ChannelId)
+	if err != nil {
+		// 影子渠道通常处于禁用状态，不在内存缓存中
+		channel, err = model.GetChannelById(mirror.Rule.ChannelId, true)
+	}
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("shadow channel #%d not found: %s", mirror.Rule.ChannelId, err.Error()))
+		return nil
+	}
+	// 请求带有路由约束时，影子渠道同样需要满足约束，否则不镜像
+	if !model.GetRouteConstraints(c).Match(channel) {
+		return nil
+	}
+	timeout := time.Duration(operation_setting.GetShadowSetting().TimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), timeout)
+	cp := c.Copy()
+	cp.Request = c.Request.Clone(ctx)
+	cp.Writer = newShadowResponseWriter(mirror.Shadow)
+	cp.Set("use_channel", []string{})
+	// 影子请求不计入在途请求
+	cp.Set(string(constant.ContextKeyInflight), nil)
+	common.SetContextKey(cp, constant.ContextKeyShadowSide, mirror.Shadow)
+	if err = middleware.SetupContextForSelectedChannel(cp, channel, originalModel); err != nil {
+		cancel()
+		common.LogError(c, fmt.Sprintf("shadow channel #%d: %s", channel.Id, err.Error()))
+		return nil
+	}
+	c.Writer = &shadowCaptureWriter{ResponseWriter: c.Writer, side: mirror.Primary}
+	common.SetContextKey(c, constant.ContextKeyShadowSide, mirror.Primary)
+	gopool.Go(func() {
+		defer cancel()
+		statusCode, message := relayShadow(cp, channel)
+		if statusCode == 0 {
+			statusCode = cp.Writer.Status()
+		}
+		if message != "" {
+			common.LogWarn(cp, fmt.Sprintf("shadow channel #%d failed: %s", channel.Id, message))
+		}
+		mirror.Shadow.Finish(channel.Id, statusCode, message)
+	})
+	return mirror
+}
+
+// finishShadowPrimary 记录主渠道一侧的最终结果，statusCode 为 0 时以写给客户端的状态码为准
+func finishShadowPrimary(c *gin.Context, mirror *service.ShadowMirror, statusCode int, message string) {
+	if statusCode == 0 {
+		statusCode = c.Writer.Status()
+	}
+	mirror.Primary.Finish(c.GetInt("channel_id"), statusCode, message)
+}
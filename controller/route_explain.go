@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting"
+	"one-api/setting/ratio_setting"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type routeExplainResponse struct {
+	Model       string                     `json:"model"`
+	Group       string                     `json:"group"`
+	Policy      string                     `json:"policy,omitempty"`
+	Constraints string                     `json:"constraints,omitempty"`
+	Checks      []string                   `json:"checks"` // 令牌或用户层面导致请求无法路由的原因
+	Routes      []*model.RouteExplainGroup `json:"routes"`
+	// 首次选择渠道时实际使用的分组与模型，没有可用渠道时为空
+	Selected *model.RouteExplainGroup `json:"selected"`
+}
+
+// ExplainChannelRoute 诊断指定令牌或用户请求某个模型时的渠道选择过程
+// 列出分组下的全部候选渠道、被排除的原因、优先级层级以及首次选择时各渠道被选中的概率，不会真正发起请求
+func ExplainChannelRoute(c *gin.Context) {
+	modelName := c.Query("model")
+	if modelName == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "模型名称不能为空",
+		})
+		return
+	}
+	resp := &routeExplainResponse{Model: modelName, Checks: []string{}, Routes: []*model.RouteExplainGroup{}}
+
+	var token *model.Token
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	if tokenId, _ := strconv.Atoi(c.Query("token_id")); tokenId != 0 {
+		var err error
+		token, err = model.GetTokenById(tokenId)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		userId = token.UserId
+		if token.Status != common.TokenStatusEnabled {
+			resp.Checks = append(resp.Checks, "令牌状态不可用")
+		}
+		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
+			resp.Checks = append(resp.Checks, "令牌已过期")
+		}
+		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
+			resp.Checks = append(resp.Checks, "令牌额度已用尽")
+		}
+		if _, isPolicy := model.CacheGetPolicy(modelName); !isPolicy && !isTokenModelAllowed(token, modelName) {
+			resp.Checks = append(resp.Checks, fmt.Sprintf("该令牌无权访问模型 %s", modelName))
+		}
+	}
+	userGroup := ""
+	if userId != 0 {
+		user, err := model.GetUserCache(userId)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if user.Status != common.UserStatusEnabled {
+			resp.Checks = append(resp.Checks, "用户已被封禁")
+		}
+		userGroup = user.Group
+	}
+
+	// 分组优先级：请求参数 > 令牌分组 > 用户分组
+	group := userGroup
+	if token != nil && token.Group != "" {
+		group = token.Group
+	}
+	if c.Query("group") != "" {
+		group = c.Query("group")
+	}
+	if group == "" {
+		group = "default"
+	}
+	resp.Group = group
+	if userId != 0 && group != userGroup {
+		if _, ok := setting.GetUserUsableGroups(userGroup)[group]; !ok {
+			resp.Checks = append(resp.Checks, fmt.Sprintf("分组 %s 对该用户不可用", group))
+		}
+	}
+	if group != "auto" && !ratio_setting.ContainsGroupRatio(group) {
+		resp.Checks = append(resp.Checks, fmt.Sprintf("分组 %s 已被弃用", group))
+	}
+
+	var constraints model.RouteConstraints
+	if header := c.Query("constraints"); header != "" {
+		var err error
+		constraints, err = model.ParseRouteConstraints(header)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的路由约束：" + err.Error(),
+			})
+			return
+		}
+		resp.Constraints = constraints.String()
+		if token != nil {
+			allowedKeys := token.GetRouteConstraintKeys()
+			for key := range constraints {
+				if !allowedKeys[key] {
+					resp.Checks = append(resp.Checks, fmt.Sprintf("该令牌不允许按标签 %s 约束路由", key))
+				}
+			}
+		}
+	}
+
+	// 虚拟策略先展开按策略选出的成员，其余成员按成员顺序展开；auto 分组按自动分组顺序展开，与故障转移的顺序一致
+	// 与分发时一致，令牌模型限制同样作用于成员模型，不允许的成员不参与路由
+	models := []string{modelName}
+	if policy, ok := model.CacheGetPolicy(modelName); ok {
+		resp.Policy = policy.Name
+		policyCtx := c.Copy()
+		common.SetContextKey(policyCtx, constant.ContextKeyUserGroup, userGroup)
+		models = []string{}
+		for _, member := range service.GetPolicyMemberOrder(policyCtx, policy, group) {
+			if !isTokenModelAllowed(token, member) {
+				resp.Checks = append(resp.Checks, fmt.Sprintf("该令牌无权访问策略成员模型 %s，已跳过", member))
+				continue
+			}
+			models = append(models, member)
+		}
+	}
+	groups := []string{group}
+	if group == "auto" {
+		groups = setting.AutoGroups
+	}
+	for _, routeModel := range models {
+		for _, routeGroup := range groups {
+			route, err := model.ExplainChannelRoute(routeGroup, routeModel, constraints)
+			if err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": err.Error(),
+				})
+				return
+			}
+			resp.Routes = append(resp.Routes, route)
+			if resp.Selected == nil && len(route.Tiers) > 0 {
+				resp.Selected = route
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    resp,
+	})
+}
+
+// isTokenModelAllowed 令牌开启模型限制时，模型需要在允许列表中；未指定令牌时总是允许
+func isTokenModelAllowed(token *model.Token, modelName string) bool {
+	if token == nil || !token.IsModelLimitsEnabled() {
+		return true
+	}
+	return token.GetModelLimitsMap()[modelName]
+}
@@ -131,6 +131,13 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := token.ValidateModelFallbacks(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "模型回退配置格式错误：" + err.Error(),
+		})
+		return
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -141,18 +148,22 @@ func AddToken(c *gin.Context) {
 		return
 	}
 	cleanToken := model.Token{
-		UserId:             c.GetInt("id"),
-		Name:               token.Name,
-		Key:                key,
-		CreatedTime:        common.GetTimestamp(),
-		AccessedTime:       common.GetTimestamp(),
-		ExpiredTime:        token.ExpiredTime,
-		RemainQuota:        token.RemainQuota,
-		UnlimitedQuota:     token.UnlimitedQuota,
-		ModelLimitsEnabled: token.ModelLimitsEnabled,
-		ModelLimits:        token.ModelLimits,
-		AllowIps:           token.AllowIps,
-		Group:              token.Group,
+		UserId:              c.GetInt("id"),
+		Name:                token.Name,
+		Key:                 key,
+		CreatedTime:         common.GetTimestamp(),
+		AccessedTime:        common.GetTimestamp(),
+		ExpiredTime:         token.ExpiredTime,
+		RemainQuota:         token.RemainQuota,
+		UnlimitedQuota:      token.UnlimitedQuota,
+		ModelLimitsEnabled:  token.ModelLimitsEnabled,
+		ModelLimits:         token.ModelLimits,
+		AllowIps:            token.AllowIps,
+		Group:               token.Group,
+		GrayLogicOptOut:     token.GrayLogicOptOut,
+		MaxConcurrency:      token.MaxConcurrency,
+		ModelFallbacks:      token.ModelFallbacks,
+		RouteConstraintKeys: token.RouteConstraintKeys,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -206,6 +217,13 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := token.ValidateModelFallbacks(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "模型回退配置格式错误：" + err.Error(),
+		})
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -242,6 +260,10 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
+		cleanToken.GrayLogicOptOut = token.GrayLogicOptOut
+		cleanToken.MaxConcurrency = token.MaxConcurrency
+		cleanToken.ModelFallbacks = token.ModelFallbacks
+		cleanToken.RouteConstraintKeys = token.RouteConstraintKeys
 	}
 	err = cleanToken.Update()
 	if err != nil {
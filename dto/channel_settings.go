@@ -4,4 +4,47 @@ type ChannelSettings struct {
 	ForceFormat       bool   `json:"force_format,omitempty"`
 	ThinkingToContent bool   `json:"thinking_to_content,omitempty"`
 	Proxy             string `json:"proxy"`
+	// 渠道级每分钟请求数与 token 数预算，0 表示不限制
+	RPMLimit int `json:"rpm_limit,omitempty"`
+	TPMLimit int `json:"tpm_limit,omitempty"`
+	// 按模型单独配置的预算，与渠道级预算同时生效
+	ModelRateLimits map[string]ChannelRateLimit `json:"model_rate_limits,omitempty"`
+	// 按时间生效的可用窗口与优先级、权重覆盖
+	Schedule *ChannelSchedule `json:"schedule,omitempty"`
+	// 自由格式的渠道标签，例如 {"region": "eu", "provider": "azure"}，客户端可以通过 X-Route-Constraints 按标签筛选渠道
+	Labels map[string]string `json:"labels,omitempty"`
+	// 灰度放量，新渠道先只承接同一优先级的少量流量
+	Canary *ChannelCanary `json:"canary,omitempty"`
+}
+
+type ChannelRateLimit struct {
+	RPM int `json:"rpm,omitempty"`
+	TPM int `json:"tpm,omitempty"`
+}
+
+// ChannelSchedule 渠道或标签的时间计划，时间窗口使用 5 段 cron 表达式（分 时 日 月 周），当前分钟匹配即处于窗口内
+type ChannelSchedule struct {
+	Timezone    string                    `json:"timezone,omitempty"`    // IANA 时区名，为空时使用服务器时区
+	Available   []string                  `json:"available,omitempty"`   // 仅在任一窗口内可用，为空表示始终可用
+	Unavailable []string                  `json:"unavailable,omitempty"` // 在任一窗口内不可用，例如每周维护
+	Overrides   []ChannelScheduleOverride `json:"overrides,omitempty"`   // 按顺序取第一个匹配的覆盖
+}
+
+type ChannelScheduleOverride struct {
+	Cron     string `json:"cron"`
+	Priority *int64 `json:"priority,omitempty"`
+	Weight   *uint  `json:"weight,omitempty"`
+}
+
+// ChannelCanary 渠道的灰度放量配置，未设置的参数使用默认值
+// 每个阶段持续 StepMinutes 分钟且请求数达到 MinRequests 后，错误率低于阈值时将 Percent 提高 StepPercent，达到 100 时灰度结束
+// 阶段内错误率达到阈值时渠道被回滚为手动禁用，Percent 保持不变，重新启用后从当前比例继续放量
+type ChannelCanary struct {
+	Enabled            bool    `json:"enabled"`
+	Percent            float64 `json:"percent"`              // 当前放量比例 0-100，即在同一优先级中承接的流量百分比
+	StepPercent        float64 `json:"step_percent"`         // 每个阶段提高的比例
+	StepMinutes        int     `json:"step_minutes"`         // 每个阶段的最短持续时间
+	ErrorRateThreshold float64 `json:"error_rate_threshold"` // 0-1
+	MinRequests        int     `json:"min_requests"`         // 放量或回滚所需的阶段内最少请求数
+	StepStartedAt      int64   `json:"step_started_at"`      // 当前阶段的开始时间，由系统维护，为 0 时以首次请求的时间为准
 }
@@ -32,6 +32,10 @@ var buildFS embed.FS
 var indexPage []byte
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		runSimulate(os.Args[2:])
+		return
+	}
 
 	err := InitResources()
 	if err != nil {
@@ -76,6 +80,7 @@ func main() {
 			}()
 			model.InitChannelCache()
 		}()
+		model.InitPolicyCache()
 
 		go model.SyncChannelCache(common.SyncFrequency)
 	}
@@ -83,6 +88,11 @@ func main() {
 	// 热更新配置
 	go model.SyncOptions(common.SyncFrequency)
 
+	// 集群并发计数
+	if common.RedisEnabled {
+		go common.SyncActiveRequests(common.ActiveRequestsHeartbeatInterval)
+	}
+
 	// 数据看板
 	go model.UpdateQuotaData()
 
@@ -100,6 +110,9 @@ func main() {
 		}
 		go controller.AutomaticallyTestChannels(frequency)
 	}
+	if common.IsMasterNode {
+		go controller.AutomaticallyProbeChannels()
+	}
 	if common.IsMasterNode && constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateMidjourneyTaskBulk()
@@ -249,6 +249,10 @@ func TokenAuth() func(c *gin.Context) {
 		}
 		c.Set("allow_ips", token.GetIpLimitsMap())
 		c.Set("token_group", token.Group)
+		c.Set("token_gray_logic_opt_out", token.GrayLogicOptOut)
+		c.Set("token_max_concurrency", token.MaxConcurrency)
+		c.Set("token_model_fallbacks", token.GetModelFallbacks())
+		c.Set("token_route_constraint_keys", token.GetRouteConstraintKeys())
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set("specific_channel_id", parts[1])
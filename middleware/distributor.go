@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 )
 
 type ModelRequest struct {
@@ -41,25 +42,6 @@ func Distribute() func(c *gin.Context) {
 		return
 	}
 
-	// VERIFLOW_DEBUG: 虚拟策略模型处理 - 在查找渠道之前执行
-	if common.IsVirtualPolicyModel(modelRequest.Model) {
-		originalModelName := modelRequest.Model
-		// 解析虚拟策略模型为真实模型
-		realModel, isVirtual := common.ResolveVirtualPolicyModel(originalModelName)
-		if isVirtual {
-			// 记录策略路由日志
-			currentLoad := common.GetActiveRequests()
-			common.LogInfo(c, fmt.Sprintf("VERIFLOW_DEBUG: Virtual Policy triggered. Current load: %d. Rerouting '%s' to '%s'", 
-				currentLoad, originalModelName, realModel))
-			
-			// 更新模型请求中的模型名称为真实模型，用于后续的渠道查找
-			modelRequest.Model = realModel
-			
-			// 保存原始的虚拟策略模型名称到 gin.Context，用于后续的模型名称欺骗
-			c.Set("original_model_name", originalModelName)
-		}
-	}
-
 	userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
 		tokenGroup := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
 		if tokenGroup != "" {
@@ -78,6 +60,49 @@ func Distribute() func(c *gin.Context) {
 			userGroup = tokenGroup
 		}
 		common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
+
+		// 客户端按渠道标签约束候选渠道，只允许约束令牌许可的标签
+		if header := c.Request.Header.Get(RouteConstraintsHeader); header != "" && shouldSelectChannel {
+			constraints, err := model.ParseRouteConstraints(header)
+			if err != nil {
+				abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的路由约束："+err.Error())
+				return
+			}
+			allowedKeys, _ := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenRouteConstraintKeys)
+			for key := range constraints {
+				if !allowedKeys[key] {
+					abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌不允许按标签 %s 约束路由", key))
+					return
+				}
+			}
+			common.SetContextKey(c, constant.ContextKeyRouteConstraints, constraints)
+		}
+
+		// 读取会话的粘性路由，用于保持虚拟策略成员模型与渠道
+		if shouldSelectChannel {
+			service.LoadStickyRoute(c, modelRequest.Model)
+		}
+
+		// VERIFLOW_DEBUG: 虚拟策略模型处理 - 在查找渠道之前执行
+		policy, realModel, isVirtual := service.ResolveVirtualPolicyModel(c, modelRequest.Model, userGroup)
+		if isVirtual {
+			originalModelName := modelRequest.Model
+			// 记录策略路由日志
+			currentLoad := common.GetActiveRequests()
+			common.LogInfo(c, fmt.Sprintf("VERIFLOW_DEBUG: Virtual Policy triggered. Strategy: %s. Current load: %d. Rerouting '%s' to '%s'",
+				policy.Strategy, currentLoad, originalModelName, realModel))
+
+			// 更新模型请求中的模型名称为真实模型，用于后续的渠道查找
+			modelRequest.Model = realModel
+
+			// 保存原始的虚拟策略模型名称到 gin.Context，用于后续的模型名称欺骗
+			c.Set("original_model_name", originalModelName)
+			// 记录策略名称及已尝试的成员模型，用于后续的成员故障转移
+			common.SetContextKey(c, constant.ContextKeyPolicyName, policy.Name)
+			common.SetContextKey(c, constant.ContextKeyPolicyStrategy, policy.Strategy)
+			common.SetContextKey(c, constant.ContextKeyPolicyTriedModels, []string{realModel})
+		}
+
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
@@ -119,13 +144,36 @@ func Distribute() func(c *gin.Context) {
 
 			if shouldSelectChannel {
 				var selectGroup string
-				channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
+				if stickyChannel, stickyGroup, ok := service.GetStickyChannel(c, userGroup, modelRequest.Model); ok {
+					channel, selectGroup = stickyChannel, stickyGroup
+				} else if affinityChannel, affinityGroup, ok := service.GetPromptAffinityChannel(c, userGroup, modelRequest.Model); ok {
+					channel, selectGroup = affinityChannel, affinityGroup
+				} else {
+					channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
+				}
+				if err != nil && isVirtual {
+					// 首选成员模型无可用渠道，按策略顺序尝试其余成员模型
+					if memberChannel, memberModel, memberGroup, ok := SelectNextPolicyMemberChannel(c, userGroup); ok {
+						channel, selectGroup, err = memberChannel, memberGroup, nil
+						modelRequest.Model = memberModel
+					}
+				}
+				if err != nil {
+					// 主模型无可用渠道，按回退链尝试其他模型
+					if fallbackChannel, fallbackModel, ok := SelectNextFallbackModelChannel(c, userGroup, modelRequest.Model); ok {
+						channel, err = fallbackChannel, nil
+						modelRequest.Model = fallbackModel
+					}
+				}
 				if err != nil {
 					showGroup := userGroup
 					if userGroup == "auto" {
 						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
 					}
 					message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", showGroup, modelRequest.Model)
+					if errors.Is(err, model.ErrRouteConstraintsUnsatisfied) {
+						message = fmt.Sprintf("当前分组 %s 下对于模型 %s 没有满足路由约束 %s 的渠道", showGroup, modelRequest.Model, model.GetRouteConstraints(c))
+					}
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
 					if channel != nil {
 						common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
@@ -142,9 +190,153 @@ func Distribute() func(c *gin.Context) {
 			}
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
-		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		// 由选择器选出的渠道已占用一次 RPM 额度，放弃该渠道时需要归还
+		_, specificChannel := c.Get("specific_channel_id")
+		selected := shouldSelectChannel && !specificChannel
+		if err := SetupContextForSelectedChannel(c, channel, modelRequest.Model); err != nil {
+			if selected {
+				model.ReleaseChannelRateLimit(channel, modelRequest.Model)
+			}
+			abortWithOpenAiMessage(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		// 在途请求从分发开始统计，直到响应（包括流式响应）完全写出
+		if err := startInflightWithReselect(c, userGroup, modelRequest.Model, channel, selected); err != nil {
+			switch {
+			case errors.Is(err, common.ErrUserInflightLimit):
+				abortWithOpenAiMessage(c, http.StatusTooManyRequests, err.Error(), "concurrency_limit_exceeded")
+			case errors.Is(err, common.ErrChannelInflightLimit):
+				abortWithOpenAiMessage(c, http.StatusServiceUnavailable, err.Error(), "channel_concurrency_limit_exceeded")
+			default:
+				abortWithOpenAiMessage(c, http.StatusServiceUnavailable, err.Error())
+			}
+			return
+		}
+		defer service.FinishInflight(c)
 		c.Next()
+		service.SaveStickyRoute(c)
+	}
+}
+
+// startInflightWithReselect 计入在途请求；所选渠道在选中后被其他请求占满时，由选择器选出的渠道（selected）改选其他未满的渠道
+// 用户或令牌的并发已达上限时直接返回 common.ErrUserInflightLimit；被放弃的渠道归还选择时占用的 RPM 额度
+func startInflightWithReselect(c *gin.Context, group string, modelName string, channel *model.Channel, selected bool) error {
+	err := service.StartInflight(c)
+	for i := 0; selected && i < 3 && errors.Is(err, common.ErrChannelInflightLimit); i++ {
+		model.ReleaseChannelRateLimit(channel, modelName)
+		next, _, selectErr := model.CacheGetRandomSatisfiedChannel(c, group, modelName, 0)
+		if selectErr != nil {
+			return err
+		}
+		channel = next
+		if setupErr := SetupContextForSelectedChannel(c, channel, modelName); setupErr != nil {
+			model.ReleaseChannelRateLimit(channel, modelName)
+			return setupErr
+		}
+		err = service.StartInflight(c)
+	}
+	if err != nil && selected {
+		model.ReleaseChannelRateLimit(channel, modelName)
+	}
+	return err
+}
+
+// SelectNextPolicyMemberChannel 按虚拟策略的成员顺序，为尚未尝试过的成员模型选择渠道
+// 每次切换都会写入 use_channel，使重试日志中能看到成员模型的切换
+func SelectNextPolicyMemberChannel(c *gin.Context, group string) (*model.Channel, string, string, bool) {
+	policy, ok := model.CacheGetPolicy(common.GetContextKeyString(c, constant.ContextKeyPolicyName))
+	if !ok {
+		return nil, "", group, false
+	}
+	triedModels := common.GetContextKeyStringSlice(c, constant.ContextKeyPolicyTriedModels)
+	for _, memberModel := range policy.GetMemberModels() {
+		if lo.Contains(triedModels, memberModel) {
+			continue
+		}
+		triedModels = append(triedModels, memberModel)
+		common.SetContextKey(c, constant.ContextKeyPolicyTriedModels, triedModels)
+		if !IsTokenModelAllowed(c, memberModel) {
+			continue
+		}
+		channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, memberModel, 0)
+		if err != nil || channel == nil {
+			common.LogInfo(c, fmt.Sprintf("virtual policy %s: member model %s has no available channel", policy.Name, memberModel))
+			continue
+		}
+		common.LogInfo(c, fmt.Sprintf("virtual policy %s: failover to member model %s", policy.Name, memberModel))
+		c.Set("use_channel", append(c.GetStringSlice("use_channel"), "model:"+memberModel))
+		return channel, memberModel, selectGroup, true
+	}
+	return nil, "", group, false
+}
+
+// FallbackModelHeader 发生模型回退时写入响应的头，值为实际响应请求的模型
+const FallbackModelHeader = "X-New-Api-Model"
+
+// RouteConstraintsHeader 客户端指定渠道标签约束的请求头，格式见 model.RouteConstraints
+const RouteConstraintsHeader = "X-Route-Constraints"
+
+// SelectNextFallbackModelChannel 主模型的渠道全部失败后，按回退链为尚未尝试过的模型选择渠道
+// 令牌配置了该模型的回退链时覆盖分组配置；虚拟策略请求以策略名称查找回退链
+func SelectNextFallbackModelChannel(c *gin.Context, group string, currentModel string) (*model.Channel, string, bool) {
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return nil, "", false
+	}
+	primaryModel := common.GetContextKeyString(c, constant.ContextKeyFallbackFrom)
+	if primaryModel == "" {
+		primaryModel = common.GetContextKeyString(c, constant.ContextKeyPolicyName)
 	}
+	if primaryModel == "" {
+		primaryModel = currentModel
+	}
+	chain, ok := getModelFallbackChain(c, group, primaryModel)
+	if !ok {
+		return nil, "", false
+	}
+	triedModels := common.GetContextKeyStringSlice(c, constant.ContextKeyFallbackTriedModels)
+	for _, fallbackModel := range chain {
+		if fallbackModel == primaryModel || lo.Contains(triedModels, fallbackModel) {
+			continue
+		}
+		triedModels = append(triedModels, fallbackModel)
+		common.SetContextKey(c, constant.ContextKeyFallbackTriedModels, triedModels)
+		if !IsTokenModelAllowed(c, fallbackModel) {
+			continue
+		}
+		channel, _, err := model.CacheGetRandomSatisfiedChannel(c, group, fallbackModel, 0)
+		if err != nil || channel == nil {
+			common.LogInfo(c, fmt.Sprintf("model fallback %s: %s has no available channel", primaryModel, fallbackModel))
+			continue
+		}
+		common.LogInfo(c, fmt.Sprintf("model fallback %s: failover to %s", primaryModel, fallbackModel))
+		common.SetContextKey(c, constant.ContextKeyFallbackFrom, primaryModel)
+		c.Set("use_channel", append(c.GetStringSlice("use_channel"), "model:"+fallbackModel))
+		c.Header(FallbackModelHeader, fallbackModel)
+		return channel, fallbackModel, true
+	}
+	return nil, "", false
+}
+
+func getModelFallbackChain(c *gin.Context, group string, primaryModel string) ([]string, bool) {
+	if tokenFallbacks, ok := common.GetContextKeyType[map[string][]string](c, constant.ContextKeyTokenModelFallbacks); ok {
+		if chain, ok := tokenFallbacks[primaryModel]; ok {
+			return chain, len(chain) > 0
+		}
+	}
+	if group == "auto" {
+		group = c.GetString("auto_group")
+	}
+	chain := setting.GetModelFallbackChain(group, primaryModel)
+	return chain, len(chain) > 0
+}
+
+// IsTokenModelAllowed 令牌开启模型限制时，回退模型、虚拟策略的成员模型与影子请求同样需要在允许列表中
+func IsTokenModelAllowed(c *gin.Context, modelName string) bool {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return true
+	}
+	tokenModelLimit, _ := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	return tokenModelLimit[modelName]
 }
 
 func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
@@ -260,16 +452,33 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	return &modelRequest, shouldSelectChannel, nil
 }
 
-func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
+// SetupContextForSelectedChannel 将选中的渠道写入上下文，多密钥渠道按轮询选择一个已启用的密钥
+// 多密钥渠道没有已启用的密钥时返回错误，不能把整个密钥列表作为一个密钥发送到上游
+func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) error {
 	c.Set("original_model", modelName) // for retry
 	if channel == nil {
-		return
+		return nil
+	}
+	channelKey, err := channel.SelectKey()
+	if err != nil {
+		return fmt.Errorf("渠道 #%d 不可用：%s", channel.Id, err.Error())
+	}
+	SetupContextForChannelKey(c, channel, channelKey, modelName)
+	if err = service.UpdateInflight(c); err != nil {
+		return fmt.Errorf("渠道 #%d 不可用：%s", channel.Id, err.Error())
 	}
+	return nil
+}
+
+// SetupContextForChannelKey 使用指定的密钥将渠道写入上下文，channelKey 为 nil 时使用渠道的 Key（单密钥渠道）
+func SetupContextForChannelKey(c *gin.Context, channel *model.Channel, channelKey *model.ChannelKey, modelName string) {
+	c.Set("original_model", modelName)
 	c.Set("channel_id", channel.Id)
 	c.Set("channel_name", channel.Name)
 	common.SetContextKey(c, constant.ContextKeyChannelType, channel.Type)
 	c.Set("channel_create_time", channel.CreatedTime)
 	common.SetContextKey(c, constant.ContextKeyChannelSetting, channel.GetSetting())
+	common.SetContextKey(c, constant.ContextKeyChannelMaxConcurrency, channel.GetMaxConcurrency())
 	c.Set("param_override", channel.GetParamOverride())
 	if nil != channel.OpenAIOrganization && "" != *channel.OpenAIOrganization {
 		c.Set("channel_organization", *channel.OpenAIOrganization)
@@ -277,7 +486,13 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	c.Set("auto_ban", channel.GetAutoBan())
 	c.Set("model_mapping", channel.GetModelMapping())
 	c.Set("status_code_mapping", channel.GetStatusCodeMapping())
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	key := channel.Key
+	common.SetContextKey(c, constant.ContextKeyChannelKeyId, 0)
+	if channelKey != nil {
+		key = channelKey.Key
+		common.SetContextKey(c, constant.ContextKeyChannelKeyId, channelKey.Id)
+	}
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	common.SetContextKey(c, constant.ContextKeyBaseUrl, channel.GetBaseURL())
 	// TODO: api_version统一
 	switch channel.Type {
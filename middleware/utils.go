@@ -6,13 +6,17 @@ import (
 	"one-api/common"
 )
 
-func abortWithOpenAiMessage(c *gin.Context, statusCode int, message string) {
+func abortWithOpenAiMessage(c *gin.Context, statusCode int, message string, code ...string) {
 	userId := c.GetInt("id")
+	openAiError := gin.H{
+		"message": common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+		"type":    "new_api_error",
+	}
+	if len(code) > 0 {
+		openAiError["code"] = code[0]
+	}
 	c.JSON(statusCode, gin.H{
-		"error": gin.H{
-			"message": common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
-			"type":    "new_api_error",
-		},
+		"error": openAiError,
 	})
 	c.Abort()
 	common.LogError(c.Request.Context(), fmt.Sprintf("user %d | %s", userId, message))
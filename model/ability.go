@@ -6,6 +6,7 @@ import (
 	"one-api/common"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/samber/lo"
 	"gorm.io/gorm"
@@ -57,12 +58,41 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
+// getAbilityModels 返回分组下可以提供模型的能力模型名
+// 存在已启用的精确能力时只使用模型本身，否则使用所有匹配的模型通配符，与内存缓存的规则一致
+func getAbilityModels(group string, model string) []string {
+	var count int64
+	DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).Count(&count)
+	if count > 0 {
+		return []string{model}
+	}
+	patterns, err := getAbilityModelPatterns(group, model, true)
+	if err != nil || len(patterns) == 0 {
+		return []string{model}
+	}
+	return patterns
+}
+
+// getAbilityModelPatterns 返回分组下匹配模型的模型通配符
+func getAbilityModelPatterns(group string, model string, enabledOnly bool) ([]string, error) {
+	var models []string
+	query := DB.Model(&Ability{}).Distinct("model").Where(commonGroupCol+" = ?", group)
+	if enabledOnly {
+		query = query.Where("enabled = ?", true)
+	}
+	err := query.Pluck("model", &models).Error
+	if err != nil {
+		return nil, err
+	}
+	return common.NewModelPatternIndex(models).Match(model), nil
+}
+
+func getPriority(group string, models []string, retry int) (int, error) {
 
 	var priorities []int
 	err := DB.Model(&Ability{}).
 		Select("DISTINCT(priority)").
-		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
+		Where(commonGroupCol+" = ? and model in ? and enabled = ?", group, models, true).
 		Order("priority DESC").              // 按优先级降序排序
 		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
 
@@ -87,26 +117,26 @@ func getPriority(group string, model string, retry int) (int, error) {
 	return priorityToUse, nil
 }
 
-func getChannelQuery(group string, model string, retry int) *gorm.DB {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
-	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
+func getChannelQuery(group string, models []string, retry int) *gorm.DB {
+	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model in ? and enabled = ?", group, models, true)
+	channelQuery := DB.Where(commonGroupCol+" = ? and model in ? and enabled = ? and priority = (?)", group, models, true, maxPrioritySubQuery)
 	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
+		priority, err := getPriority(group, models, retry)
 		if err != nil {
 			common.SysError(fmt.Sprintf("Get priority failed: %s", err.Error()))
 		} else {
-			channelQuery = DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = ?", group, model, true, priority)
+			channelQuery = DB.Where(commonGroupCol+" = ? and model in ? and enabled = ? and priority = ?", group, models, true, priority)
 		}
 	}
 
 	return channelQuery
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func GetRandomSatisfiedChannel(group string, model string, retry int, constraints RouteConstraints) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
-	channelQuery := getChannelQuery(group, model, retry)
+	channelQuery := getChannelQuery(group, getAbilityModels(group, model), retry)
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("weight DESC").Find(&abilities).Error
 	} else {
@@ -115,8 +145,18 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	if err != nil {
 		return nil, err
 	}
-	channel := Channel{}
-	if len(abilities) > 0 {
+	// 渠道的多个模型通配符同时匹配时只保留一条能力
+	abilities = lo.UniqBy(abilities, func(ability Ability) int { return ability.ChannelId })
+	abilities, err = filterUnavailableAbilities(abilities, model, constraints)
+	if err != nil {
+		return nil, err
+	}
+	if len(abilities) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	// 选中渠道的预算已耗尽时将其排除后重新选择
+	for len(abilities) > 0 {
+		channel := Channel{}
 		// Randomly choose one
 		weightSum := uint(0)
 		for _, ability_ := range abilities {
@@ -132,11 +172,96 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 				break
 			}
 		}
-	} else {
-		return nil, errors.New("channel not found")
+		err = DB.First(&channel, "id = ?", channel.Id).Error
+		if err != nil {
+			return nil, err
+		}
+		if TryAcquireChannelRateLimit(&channel, model) {
+			return &channel, nil
+		}
+		abilities = lo.Filter(abilities, func(ability Ability, _ int) bool { return ability.ChannelId != channel.Id })
+	}
+	return nil, errors.New("all channels have exhausted their rate limit budget")
+}
+
+// filterUnavailableAbilities 跳过并发已满或已熔断的渠道以及未被灰度抽中的渠道
+func filterUnavailableAbilities(abilities []Ability, model string, constraints RouteConstraints) ([]Ability, error) {
+	if len(abilities) == 0 {
+		return abilities, nil
+	}
+	// 路由约束与时间计划都依赖渠道的设置与标签；时间计划中的优先级与权重覆盖只在内存缓存模式下生效，这里仅排除不在可用时间窗口内的渠道
+	channelIds := lo.Uniq(lo.Map(abilities, func(ability Ability, _ int) int { return ability.ChannelId }))
+	var channels []Channel
+	err := DB.Select("id", "setting", "tag").Where("id in ?", channelIds).Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	unmatchedIds := make(map[int]bool)
+	unscheduledIds := make(map[int]bool)
+	for _, channel := range channels {
+		if !constraints.Match(&channel) {
+			unmatchedIds[channel.Id] = true
+		} else if !channel.IsScheduledAvailable(now) {
+			unscheduledIds[channel.Id] = true
+		}
+	}
+	if len(unmatchedIds) > 0 {
+		abilities = lo.Filter(abilities, func(ability Ability, _ int) bool { return !unmatchedIds[ability.ChannelId] })
+		if len(abilities) == 0 {
+			return nil, ErrRouteConstraintsUnsatisfied
+		}
+	}
+	abilities = lo.Filter(abilities, func(ability Ability, _ int) bool { return IsCircuitBreakerAllowed(ability.ChannelId, model) })
+	if len(abilities) == 0 {
+		return nil, errors.New("all channels are circuit broken")
+	}
+	if len(unscheduledIds) > 0 {
+		abilities = lo.Filter(abilities, func(ability Ability, _ int) bool { return !unscheduledIds[ability.ChannelId] })
+		if len(abilities) == 0 {
+			return nil, errors.New("all channels are out of schedule")
+		}
+	}
+	channelIds = lo.Uniq(lo.Map(abilities, func(ability Ability, _ int) int { return ability.ChannelId }))
+	var saturated []Channel
+	err = DB.Select("id", "max_concurrency").Where("id in ? and max_concurrency > 0", channelIds).Find(&saturated).Error
+	if err != nil {
+		return nil, err
+	}
+	saturatedIds := make(map[int]bool)
+	for _, channel := range saturated {
+		if channel.IsSaturated() {
+			saturatedIds[channel.Id] = true
+		}
+	}
+	if len(saturatedIds) > 0 {
+		abilities = lo.Filter(abilities, func(ability Ability, _ int) bool { return !saturatedIds[ability.ChannelId] })
+		if len(abilities) == 0 {
+			return nil, errors.New("all channels are saturated")
+		}
+	}
+	return sampleCanaryAbilities(abilities, channels), nil
+}
+
+// sampleCanaryAbilities 灰度渠道按放量比例承接流量，抽中时只保留该渠道，未被抽中的灰度渠道只在没有其他可用渠道时保留
+func sampleCanaryAbilities(abilities []Ability, channels []Channel) []Ability {
+	channelMap := make(map[int]*Channel, len(channels))
+	for i := range channels {
+		channelMap[channels[i].Id] = &channels[i]
+	}
+	candidates := lo.FilterMap(abilities, func(ability Ability, _ int) (*Channel, bool) {
+		channel, ok := channelMap[ability.ChannelId]
+		return channel, ok
+	})
+	canary, others, skipped := sampleCanaryChannel(candidates)
+	if canary != nil {
+		return lo.Filter(abilities, func(ability Ability, _ int) bool { return ability.ChannelId == canary.Id })
+	}
+	if len(skipped) == 0 || len(others) == 0 {
+		return abilities
 	}
-	err = DB.First(&channel, "id = ?", channel.Id).Error
-	return &channel, err
+	skippedIds := lo.SliceToMap(skipped, func(channel *Channel) (int, bool) { return channel.Id, true })
+	return lo.Filter(abilities, func(ability Ability, _ int) bool { return !skippedIds[ability.ChannelId] })
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -6,16 +6,21 @@ import (
 	"math/rand"
 	"one-api/common"
 	"one-api/setting"
+	"one-api/setting/operation_setting"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 )
 
 var group2model2channels map[string]map[string][]*Channel
+var group2modelPatterns map[string]*common.ModelPatternIndex
 var channelsIDM map[int]*Channel
+var channelId2keys map[int][]*ChannelKey
 var channelSyncLock sync.RWMutex
 
 func InitChannelCache() {
@@ -53,7 +58,18 @@ func InitChannelCache() {
 		}
 	}
 
+	newChannelId2keys := make(map[int][]*ChannelKey)
+	multiKeyChannelIds := lo.FilterMap(channels, func(channel *Channel, _ int) (int, bool) { return channel.Id, channel.IsMultiKey() })
+	if len(multiKeyChannelIds) > 0 {
+		var keys []*ChannelKey
+		DB.Where("channel_id in ?", multiKeyChannelIds).Order("id asc").Find(&keys)
+		for _, key := range keys {
+			newChannelId2keys[key.ChannelId] = append(newChannelId2keys[key.ChannelId], key)
+		}
+	}
+
 	// sort by priority
+	newGroup2modelPatterns := make(map[string]*common.ModelPatternIndex)
 	for group, model2channels := range newGroup2model2channels {
 		for model, channels := range model2channels {
 			sort.Slice(channels, func(i, j int) bool {
@@ -61,11 +77,14 @@ func InitChannelCache() {
 			})
 			newGroup2model2channels[group][model] = channels
 		}
+		newGroup2modelPatterns[group] = common.NewModelPatternIndex(lo.Keys(model2channels))
 	}
 
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
+	group2modelPatterns = newGroup2modelPatterns
 	channelsIDM = newChannelsIDM
+	channelId2keys = newChannelId2keys
 	channelSyncLock.Unlock()
 	common.SysLog("channels synced from database")
 }
@@ -75,6 +94,7 @@ func SyncChannelCache(frequency int) {
 		time.Sleep(time.Duration(frequency) * time.Second)
 		common.SysLog("syncing channels from database")
 		InitChannelCache()
+		InitPolicyCache()
 	}
 }
 
@@ -82,6 +102,7 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 	var channel *Channel
 	var err error
 	selectGroup := group
+	constraints := GetRouteConstraints(c)
 	if group == "auto" {
 		if len(setting.AutoGroups) == 0 {
 			return nil, selectGroup, errors.New("auto groups is not enabled")
@@ -90,8 +111,12 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			if common.DebugEnabled {
 				println("autoGroup:", autoGroup)
 			}
-			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry)
+			var autoErr error
+			channel, autoErr = getRandomSatisfiedChannel(autoGroup, model, retry, constraints)
 			if channel == nil {
+				if errors.Is(autoErr, ErrRouteConstraintsUnsatisfied) {
+					err = autoErr
+				}
 				continue
 			} else {
 				c.Set("auto_group", autoGroup)
@@ -103,41 +128,76 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			}
 		}
 	} else {
-		channel, err = getRandomSatisfiedChannel(group, model, retry)
+		channel, err = getRandomSatisfiedChannel(group, model, retry, constraints)
 		if err != nil {
 			return nil, group, err
 		}
 	}
 	if channel == nil {
+		if err != nil {
+			return nil, group, err
+		}
 		return nil, group, errors.New("channel not found")
 	}
 	return channel, selectGroup, nil
 }
 
-func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
-	if strings.HasPrefix(model, "gpt-4-gizmo") {
-		model = "gpt-4-gizmo-*"
+// getGroupModelChannels 返回分组下可以提供模型的渠道，按渠道优先级降序排列
+// 存在精确列出该模型的渠道时只使用这些渠道，否则合并所有匹配的模型通配符的渠道；调用方需要持有 channelSyncLock 读锁
+func getGroupModelChannels(group string, model string) []*Channel {
+	if channels := group2model2channels[group][model]; len(channels) > 0 {
+		return channels
 	}
-	if strings.HasPrefix(model, "gpt-4o-gizmo") {
-		model = "gpt-4o-gizmo-*"
+	patterns := group2modelPatterns[group].Match(model)
+	if len(patterns) == 1 {
+		return group2model2channels[group][patterns[0]]
 	}
+	var channels []*Channel
+	for _, pattern := range patterns {
+		channels = append(channels, group2model2channels[group][pattern]...)
+	}
+	channels = lo.UniqBy(channels, func(channel *Channel) int { return channel.Id })
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].GetPriority() > channels[j].GetPriority()
+	})
+	return channels
+}
 
+func getRandomSatisfiedChannel(group string, model string, retry int, constraints RouteConstraints) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		return GetRandomSatisfiedChannel(group, model, retry, constraints)
 	}
 
 	channelSyncLock.RLock()
-	channels := group2model2channels[group][model]
+	channels := getGroupModelChannels(group, model)
 	channelSyncLock.RUnlock()
 
 	if len(channels) == 0 {
 		return nil, errors.New("channel not found")
 	}
 
+	// 只保留标签满足客户端路由约束的渠道
+	if len(constraints) > 0 {
+		channels = lo.Filter(channels, func(channel *Channel, _ int) bool { return constraints.Match(channel) })
+		if len(channels) == 0 {
+			return nil, ErrRouteConstraintsUnsatisfied
+		}
+	}
+
+	// 跳过并发已满、已熔断或不在可用时间窗口内的渠道
+	now := time.Now()
+	channels = lo.Filter(channels, func(channel *Channel, _ int) bool {
+		return !channel.IsSaturated() && IsCircuitBreakerAllowed(channel.Id, model) && channel.IsScheduledAvailable(now)
+	})
+	if len(channels) == 0 {
+		return nil, errors.New("all channels are saturated, circuit broken or out of schedule")
+	}
+
+	// 优先级与权重按时间计划的覆盖计算
 	uniquePriorities := make(map[int]bool)
 	for _, channel := range channels {
-		uniquePriorities[int(channel.GetPriority())] = true
+		uniquePriorities[int(channel.GetScheduledPriority(now))] = true
 	}
 	var sortedUniquePriorities []int
 	for priority := range uniquePriorities {
@@ -148,35 +208,66 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	if retry >= len(uniquePriorities) {
 		retry = len(uniquePriorities) - 1
 	}
-	targetPriority := int64(sortedUniquePriorities[retry])
 
-	// get the priority for the given retry number
-	var targetChannels []*Channel
-	for _, channel := range channels {
-		if channel.GetPriority() == targetPriority {
-			targetChannels = append(targetChannels, channel)
+	// 从重试次数对应的优先级开始选择，某一优先级的渠道预算全部耗尽时顺延到更低的优先级
+	// 灰度渠道按放量比例承接所在优先级的流量，未被抽中的灰度渠道只在没有其他可用渠道时使用
+	var skippedCanaries []*Channel
+	for _, priority := range sortedUniquePriorities[retry:] {
+		var targetChannels []*Channel
+		for _, channel := range channels {
+			if channel.GetScheduledPriority(now) == int64(priority) {
+				targetChannels = append(targetChannels, channel)
+			}
 		}
+		canary, targetChannels, skipped := sampleCanaryChannel(targetChannels)
+		skippedCanaries = append(skippedCanaries, skipped...)
+		if canary != nil && TryAcquireChannelRateLimit(canary, model) {
+			return canary, nil
+		}
+		if channel := pickAvailableChannel(targetChannels, model, now); channel != nil {
+			return channel, nil
+		}
+	}
+	if channel := pickAvailableChannel(skippedCanaries, model, now); channel != nil {
+		return channel, nil
+	}
+	return nil, errors.New("all channels have exhausted their rate limit budget")
+}
+
+// pickAvailableChannel 按权重选择渠道，选中渠道的预算已耗尽时将其排除后重新选择，全部耗尽时返回 nil
+func pickAvailableChannel(targetChannels []*Channel, model string, now time.Time) *Channel {
+	for len(targetChannels) > 0 {
+		channel := pickWeightedChannel(targetChannels, model, now)
+		if TryAcquireChannelRateLimit(channel, model) {
+			return channel
+		}
+		targetChannels = lo.Without(targetChannels, channel)
 	}
+	return nil
+}
 
+func pickWeightedChannel(targetChannels []*Channel, model string, now time.Time) *Channel {
 	// 平滑系数
 	smoothingFactor := 10
+	if operation_setting.GetLatencyRoutingSetting().Enabled {
+		return pickLatencyAwareChannel(targetChannels, model, smoothingFactor, now)
+	}
 	// Calculate the total weight of all channels up to endIdx
 	totalWeight := 0
 	for _, channel := range targetChannels {
-		totalWeight += channel.GetWeight() + smoothingFactor
+		totalWeight += channel.GetScheduledWeight(now) + smoothingFactor
 	}
 	// Generate a random value in the range [0, totalWeight)
 	randomWeight := rand.Intn(totalWeight)
 
 	// Find a channel based on its weight
 	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight() + smoothingFactor
+		randomWeight -= channel.GetScheduledWeight(now) + smoothingFactor
 		if randomWeight < 0 {
-			return channel, nil
+			return channel
 		}
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	return targetChannels[len(targetChannels)-1]
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
@@ -193,6 +284,32 @@ func CacheGetChannel(id int) (*Channel, error) {
 	return c, nil
 }
 
+// cacheUpdateChannel 复制内存缓存中的渠道，修改副本后替换原渠道
+// 已经取出的渠道对象与渠道列表不会被修改，请求路径在释放锁之后读取渠道字段不会产生数据竞争
+func cacheUpdateChannel(id int, update func(channel *Channel)) {
+	if !common.MemoryCacheEnabled {
+		return
+	}
+	channelSyncLock.Lock()
+	defer channelSyncLock.Unlock()
+	cached, ok := channelsIDM[id]
+	if !ok {
+		return
+	}
+	updated := *cached
+	update(&updated)
+	channelsIDM[id] = &updated
+	for _, model2channels := range group2model2channels {
+		for model, channels := range model2channels {
+			if index := slices.Index(channels, cached); index >= 0 {
+				channels = slices.Clone(channels)
+				channels[index] = &updated
+				model2channels[model] = channels
+			}
+		}
+	}
+}
+
 func CacheUpdateChannelStatus(id int, status int) {
 	if !common.MemoryCacheEnabled {
 		return
@@ -203,3 +320,83 @@ func CacheUpdateChannelStatus(id int, status int) {
 		channel.Status = status
 	}
 }
+
+var policyName2policy map[string]*Policy
+var policySyncLock sync.RWMutex
+
+func InitPolicyCache() {
+	if !common.MemoryCacheEnabled {
+		return
+	}
+	var policies []*Policy
+	err := DB.Where("status = ?", common.PolicyStatusEnabled).Find(&policies).Error
+	if err != nil {
+		common.SysError("failed to sync policies from database: " + err.Error())
+		return
+	}
+	newPolicyName2policy := make(map[string]*Policy, len(policies))
+	for _, policy := range policies {
+		newPolicyName2policy[policy.Name] = policy
+	}
+	policySyncLock.Lock()
+	policyName2policy = newPolicyName2policy
+	policySyncLock.Unlock()
+	common.SysLog("policies synced from database")
+}
+
+// CacheGetPolicy 获取已启用的虚拟策略，未启用内存缓存时直接查询数据库
+func CacheGetPolicy(name string) (*Policy, bool) {
+	if name == "" {
+		return nil, false
+	}
+	if !common.MemoryCacheEnabled {
+		policy, err := GetEnabledPolicyByName(name)
+		if err != nil {
+			return nil, false
+		}
+		return policy, true
+	}
+	policySyncLock.RLock()
+	defer policySyncLock.RUnlock()
+	policy, ok := policyName2policy[name]
+	return policy, ok
+}
+
+// GetModelEnabledGroup 返回模型存在已启用渠道的分组，auto 分组按 AutoGroups 的顺序查找
+func GetModelEnabledGroup(group string, model string) (string, bool) {
+	groups := []string{group}
+	if group == "auto" {
+		groups = setting.AutoGroups
+	}
+	for _, g := range groups {
+		if isModelEnabledInGroup(g, model) {
+			return g, true
+		}
+	}
+	return group, false
+}
+
+func isModelEnabledInGroup(group string, model string) bool {
+	if !common.MemoryCacheEnabled {
+		var count int64
+		DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model in ? and enabled = ?", group, getAbilityModels(group, model), true).Count(&count)
+		return count > 0
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	return len(getGroupModelChannels(group, model)) > 0
+}
+
+// IsChannelEnabledForModel 指定渠道当前是否可以在分组下为模型提供服务
+func IsChannelEnabledForModel(group string, model string, channelId int) bool {
+	if !common.MemoryCacheEnabled {
+		var count int64
+		DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model in ? and channel_id = ? and enabled = ?", group, getAbilityModels(group, model), channelId, true).Count(&count)
+		return count > 0
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	return lo.ContainsBy(getGroupModelChannels(group, model), func(channel *Channel) bool {
+		return channel.Id == channelId
+	})
+}
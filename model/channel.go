@@ -2,8 +2,12 @@ package model
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
+	"one-api/setting"
 	"strings"
 	"sync"
 
@@ -33,11 +37,15 @@ type Channel struct {
 	//MaxInputTokens     *int    `json:"max_input_tokens" gorm:"default:0"`
 	StatusCodeMapping *string `json:"status_code_mapping" gorm:"type:varchar(1024);default:''"`
 	Priority          *int64  `json:"priority" gorm:"bigint;default:0"`
+	MaxConcurrency    *int    `json:"max_concurrency" gorm:"default:0"` // 每个节点上同时进行的请求数上限，0 表示不限制
 	AutoBan           *int    `json:"auto_ban" gorm:"default:1"`
 	OtherInfo         string  `json:"other_info"`
 	Tag               *string `json:"tag" gorm:"index"`
 	Setting           *string `json:"setting" gorm:"type:text"`
 	ParamOverride     *string `json:"param_override" gorm:"type:text"`
+	KeyRotation       *string `json:"key_rotation" gorm:"type:varchar(16);default:''"` // 多密钥轮换方式，非空时 Key 为按行分隔的多个密钥
+
+	CircuitBreaker *CircuitBreakerStatus `json:"circuit_breaker,omitempty" gorm:"-"` // 仅用于渠道列表展示
 }
 
 func (channel *Channel) GetModels() []string {
@@ -196,6 +204,10 @@ func BatchInsertChannels(channels []Channel) error {
 		if err != nil {
 			return err
 		}
+		err = channel_.SyncChannelKeys()
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -215,6 +227,12 @@ func BatchDeleteChannels(ids []int) error {
 		tx.Rollback()
 		return err
 	}
+	err = tx.Where("channel_id in (?)", ids).Delete(&ChannelKey{}).Error
+	if err != nil {
+		// 回滚事务
+		tx.Rollback()
+		return err
+	}
 	// 提交事务
 	tx.Commit()
 	return err
@@ -234,6 +252,20 @@ func (channel *Channel) GetWeight() int {
 	return int(*channel.Weight)
 }
 
+func (channel *Channel) GetMaxConcurrency() int {
+	if channel.MaxConcurrency == nil {
+		return 0
+	}
+	return *channel.MaxConcurrency
+}
+
+// IsSaturated 渠道在本节点上的在途请求数是否已达到并发上限，用于选择渠道时跳过已满的渠道
+// 并发上限按节点计算；选中后由 service.StartInflight 在计入在途请求时原子地检查，并发请求不会超过上限
+func (channel *Channel) IsSaturated() bool {
+	maxConcurrency := channel.GetMaxConcurrency()
+	return maxConcurrency > 0 && common.GetChannelInflight(channel.Id) >= int64(maxConcurrency)
+}
+
 func (channel *Channel) GetBaseURL() string {
 	if channel.BaseURL == nil {
 		return ""
@@ -262,18 +294,33 @@ func (channel *Channel) Insert() error {
 		return err
 	}
 	err = channel.AddAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	return channel.SyncChannelKeys()
 }
 
 func (channel *Channel) Update() error {
 	var err error
+	var originStatus int
+	DB.Model(&Channel{}).Where("id = ?", channel.Id).Pluck("status", &originStatus)
 	err = DB.Model(channel).Updates(channel).Error
 	if err != nil {
 		return err
 	}
 	DB.Model(channel).First(channel, "id = ?", channel.Id)
 	err = channel.UpdateAbilities(nil)
-	return err
+	if err != nil {
+		return err
+	}
+	err = channel.SyncChannelKeys()
+	if err != nil {
+		return err
+	}
+	if channel.IsMultiKey() && originStatus != common.ChannelStatusEnabled && channel.Status == common.ChannelStatusEnabled {
+		return restoreChannelKeys([]int{channel.Id})
+	}
+	return nil
 }
 
 func (channel *Channel) UpdateResponseTime(responseTime int64) {
@@ -303,7 +350,10 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	return DeleteChannelKeys([]int{channel.Id})
 }
 
 var channelStatusLock sync.Mutex
@@ -356,6 +406,11 @@ func UpdateChannelStatusById(id int, status int, reason string) bool {
 			return false
 		}
 	}
+	if status == common.ChannelStatusEnabled {
+		if err = restoreChannelKeys([]int{id}); err != nil {
+			common.SysError("failed to restore channel keys: " + err.Error())
+		}
+	}
 	return true
 }
 
@@ -365,7 +420,15 @@ func EnableChannelByTag(tag string) error {
 		return err
 	}
 	err = UpdateAbilityStatusByTag(tag, true)
-	return err
+	if err != nil {
+		return err
+	}
+	var channelIds []int
+	err = DB.Model(&Channel{}).Where("tag = ?", tag).Pluck("id", &channelIds).Error
+	if err != nil {
+		return err
+	}
+	return restoreChannelKeys(channelIds)
 }
 
 func DisableChannelByTag(tag string) error {
@@ -523,6 +586,31 @@ func (channel *Channel) ValidateSettings() error {
 			return err
 		}
 	}
+	switch channel.GetKeyRotation() {
+	case "", constant.ChannelKeyRotationRoundRobin, constant.ChannelKeyRotationRandom:
+	default:
+		return fmt.Errorf("不支持的密钥轮换方式：%s", channel.GetKeyRotation())
+	}
+	if channel.IsMultiKey() && channel.Key != "" && len(channel.GetKeys()) == 0 {
+		return errors.New("多密钥渠道至少需要一个密钥")
+	}
+	for _, model := range strings.Split(channel.Models, ",") {
+		if common.IsModelPattern(model) {
+			if _, err := common.CompileModelPattern(model); err != nil {
+				return err
+			}
+		}
+	}
+	if channelParams.Schedule != nil {
+		if _, err := setting.CompileChannelSchedule(channelParams.Schedule); err != nil {
+			return fmt.Errorf("渠道时间计划无效：%s", err.Error())
+		}
+	}
+	if channelParams.Canary != nil {
+		if err := validateChannelCanary(channelParams.Canary); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+type affinityNodesKey struct {
+	channelId    int
+	virtualNodes int
+}
+
+// 渠道在哈希环上的虚拟节点只与渠道 Id 有关，计算一次后缓存
+var channelAffinityNodes sync.Map // affinityNodesKey -> []uint64
+
+func getChannelAffinityNodes(channelId int, virtualNodes int) []uint64 {
+	key := affinityNodesKey{channelId, virtualNodes}
+	if nodes, ok := channelAffinityNodes.Load(key); ok {
+		return nodes.([]uint64)
+	}
+	nodes := make([]uint64, virtualNodes)
+	for i := range nodes {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("channel-%d-%d", channelId, i)))
+		nodes[i] = binary.BigEndian.Uint64(hash[:8])
+	}
+	channelAffinityNodes.Store(key, nodes)
+	return nodes
+}
+
+// affinityDistance 渠道在哈希环上距离 key 最近的顺时针距离，距离越小越先被选中
+func affinityDistance(channelId int, virtualNodes int, key uint64) uint64 {
+	distance := ^uint64(0)
+	for _, node := range getChannelAffinityNodes(channelId, virtualNodes) {
+		distance = min(distance, node-key)
+	}
+	return distance
+}
+
+// GetAffinityChannel 按一致性哈希将 key 映射到分组下模型的渠道上
+// 从最高优先级开始沿哈希环顺时针查找第一个满足路由约束、处于可用时间窗口、未满载、未熔断且预算未耗尽的渠道，渠道增减时只有少量 key 的归属会变化
+// 灰度渠道只有按放量比例被抽中时才参与本次查找，保证灰度渠道承接的流量不超过放量比例
+func GetAffinityChannel(group string, model string, key uint64, virtualNodes int, constraints RouteConstraints) (*Channel, error) {
+	if !common.MemoryCacheEnabled {
+		return nil, errors.New("prompt affinity routing requires memory cache")
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = 1
+	}
+	channelSyncLock.RLock()
+	channels := getGroupModelChannels(group, model)
+	channelSyncLock.RUnlock()
+	if len(channels) == 0 {
+		return nil, errors.New("channel not found")
+	}
+
+	type candidate struct {
+		channel  *Channel
+		priority int64
+		distance uint64
+	}
+	now := time.Now()
+	eligible := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if constraints.Match(channel) && channel.IsScheduledAvailable(now) {
+			eligible = append(eligible, channel)
+		}
+	}
+	canary, eligible, _ := sampleCanaryChannel(eligible)
+	if canary != nil {
+		eligible = append(eligible, canary)
+	}
+	candidates := make([]candidate, 0, len(eligible))
+	for _, channel := range eligible {
+		candidates = append(candidates, candidate{channel, channel.GetScheduledPriority(now), affinityDistance(channel.Id, virtualNodes, key)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority > candidates[j].priority
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+	for _, candidate := range candidates {
+		channel := candidate.channel
+		if channel.IsSaturated() || !IsCircuitBreakerAllowed(channel.Id, model) {
+			continue
+		}
+		if TryAcquireChannelRateLimit(channel, model) {
+			return channel, nil
+		}
+	}
+	return nil, errors.New("no available channel for prompt affinity")
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"errors"
+	"math/rand"
+	"one-api/common"
+	"one-api/dto"
+	"sync"
+)
+
+// 灰度配置的默认值
+const (
+	defaultCanaryPercent            = 5
+	defaultCanaryStepPercent        = 10
+	defaultCanaryStepMinutes        = 30
+	defaultCanaryErrorRateThreshold = 0.1
+	defaultCanaryMinRequests        = 20
+)
+
+// 渠道灰度配置的解析结果按 Setting 内容缓存，未开启灰度或灰度已结束时为 nil
+var channelCanaries sync.Map // setting string -> *dto.ChannelCanary
+
+// channelCanaryStat 灰度渠道在当前阶段内的请求结果，仅保存在本节点内存中
+type channelCanaryStat struct {
+	// 统计所属的阶段，渠道的灰度状态被修改后重新统计
+	percent       float64
+	stepStartedAt int64
+	since         int64 // 本节点开始统计的时间
+	requests      int
+	failures      int
+	promoting     bool // 正在保存放量后的灰度状态，期间不再重复放量
+}
+
+var channelCanaryStats = make(map[int]*channelCanaryStat)
+var channelCanaryLock sync.Mutex
+
+// ChannelCanaryEvent 一次请求结果触发的灰度状态变化
+type ChannelCanaryEvent struct {
+	ChannelId   int
+	ChannelName string
+	Rollback    bool    // 错误率达到阈值，渠道需要回滚
+	Percent     float64 // 回滚时为当前比例，放量时为新的比例，100 表示灰度结束
+	Requests    int
+	Failures    int
+	ErrorRate   float64
+	Threshold   float64
+}
+
+func normalizeChannelCanary(canary dto.ChannelCanary) *dto.ChannelCanary {
+	if canary.Percent <= 0 {
+		canary.Percent = defaultCanaryPercent
+	}
+	if canary.StepPercent <= 0 {
+		canary.StepPercent = defaultCanaryStepPercent
+	}
+	if canary.StepMinutes <= 0 {
+		canary.StepMinutes = defaultCanaryStepMinutes
+	}
+	if canary.ErrorRateThreshold <= 0 {
+		canary.ErrorRateThreshold = defaultCanaryErrorRateThreshold
+	}
+	if canary.MinRequests <= 0 {
+		canary.MinRequests = defaultCanaryMinRequests
+	}
+	return &canary
+}
+
+func validateChannelCanary(canary *dto.ChannelCanary) error {
+	if canary.Percent < 0 || canary.Percent > 100 {
+		return errors.New("灰度放量比例必须在 0 到 100 之间")
+	}
+	if canary.StepPercent < 0 || canary.StepMinutes < 0 || canary.MinRequests < 0 {
+		return errors.New("灰度放量的阶段参数不能为负数")
+	}
+	if canary.ErrorRateThreshold < 0 || canary.ErrorRateThreshold > 1 {
+		return errors.New("灰度回滚的错误率阈值必须在 0 到 1 之间")
+	}
+	return nil
+}
+
+// GetCanary 返回补全默认值后的灰度配置，未开启灰度或灰度已结束时返回 nil；返回值为共享的缓存，调用方不能修改
+func (channel *Channel) GetCanary() *dto.ChannelCanary {
+	if channel.Setting == nil || *channel.Setting == "" {
+		return nil
+	}
+	if canary, ok := channelCanaries.Load(*channel.Setting); ok {
+		return canary.(*dto.ChannelCanary)
+	}
+	var canary *dto.ChannelCanary
+	if setting := channel.GetSetting().Canary; setting != nil && setting.Enabled && setting.Percent < 100 {
+		canary = normalizeChannelCanary(*setting)
+	}
+	channelCanaries.Store(*channel.Setting, canary)
+	return canary
+}
+
+// sampleCanaryChannel 按放量比例从同一优先级的灰度渠道中抽样
+// 返回抽中的灰度渠道（未抽中时为 nil）、其余的非灰度渠道以及未抽中的灰度渠道
+func sampleCanaryChannel(channels []*Channel) (*Channel, []*Channel, []*Channel) {
+	var hit *Channel
+	var others, skipped []*Channel
+	random := rand.Float64() * 100
+	for _, channel := range channels {
+		canary := channel.GetCanary()
+		if canary == nil {
+			others = append(others, channel)
+			continue
+		}
+		if hit == nil && random < canary.Percent {
+			hit = channel
+			continue
+		}
+		random -= canary.Percent
+		skipped = append(skipped, channel)
+	}
+	return hit, others, skipped
+}
+
+// SampleCanary 按放量比例决定本次请求能否使用该渠道，非灰度渠道总是返回 true
+// 用于粘性路由等不经过 sampleCanaryChannel 选择渠道的路径
+func (channel *Channel) SampleCanary() bool {
+	canary := channel.GetCanary()
+	return canary == nil || rand.Float64()*100 < canary.Percent
+}
+
+// RecordChannelCanaryResult 记录灰度渠道的一次请求结果，阶段内错误率达到阈值时返回回滚事件
+// 阶段持续足够长且请求数足够时提高放量比例并保存，返回放量事件；其余情况返回 nil
+// 统计在锁内完成，保存放量后的灰度状态在锁外进行，不阻塞其他请求的统计
+func RecordChannelCanaryResult(channel *Channel, success bool) *ChannelCanaryEvent {
+	canary := channel.GetCanary()
+	if canary == nil {
+		return nil
+	}
+	event, next := recordChannelCanaryStat(channel, canary, success)
+	if event == nil || next == nil {
+		return event
+	}
+	err := updateChannelCanary(channel.Id, next)
+	channelCanaryLock.Lock()
+	if stat, ok := channelCanaryStats[channel.Id]; ok && stat.promoting {
+		if err != nil {
+			stat.promoting = false
+		} else {
+			delete(channelCanaryStats, channel.Id)
+		}
+	}
+	channelCanaryLock.Unlock()
+	if err != nil {
+		common.SysError("failed to update channel canary: " + err.Error())
+		return nil
+	}
+	return event
+}
+
+// recordChannelCanaryStat 在锁内计入一次请求结果，需要回滚时返回回滚事件，需要放量时返回放量事件与新的灰度状态
+func recordChannelCanaryStat(channel *Channel, canary *dto.ChannelCanary, success bool) (*ChannelCanaryEvent, *dto.ChannelCanary) {
+	now := common.GetTimestamp()
+	channelCanaryLock.Lock()
+	defer channelCanaryLock.Unlock()
+	stat, ok := channelCanaryStats[channel.Id]
+	if !ok || stat.percent != canary.Percent || stat.stepStartedAt != canary.StepStartedAt {
+		stat = &channelCanaryStat{percent: canary.Percent, stepStartedAt: canary.StepStartedAt, since: now}
+		channelCanaryStats[channel.Id] = stat
+	}
+	stat.requests++
+	if !success {
+		stat.failures++
+	}
+	if stat.requests < canary.MinRequests || stat.promoting {
+		return nil, nil
+	}
+	event := &ChannelCanaryEvent{
+		ChannelId:   channel.Id,
+		ChannelName: channel.Name,
+		Percent:     canary.Percent,
+		Requests:    stat.requests,
+		Failures:    stat.failures,
+		ErrorRate:   float64(stat.failures) / float64(stat.requests),
+		Threshold:   canary.ErrorRateThreshold,
+	}
+	if event.ErrorRate >= canary.ErrorRateThreshold {
+		delete(channelCanaryStats, channel.Id)
+		event.Rollback = true
+		return event, nil
+	}
+	stepStartedAt := canary.StepStartedAt
+	if stepStartedAt == 0 {
+		stepStartedAt = stat.since
+	}
+	if now-stepStartedAt < int64(canary.StepMinutes)*60 {
+		return nil, nil
+	}
+
+	next := *canary
+	next.Percent = min(100, canary.Percent+canary.StepPercent)
+	next.StepStartedAt = now
+	if next.Percent >= 100 {
+		next.Enabled = false
+	}
+	stat.promoting = true
+	event.Percent = next.Percent
+	return event, &next
+}
+
+// updateChannelCanary 保存渠道的灰度状态，并以替换渠道对象的方式同步到内存缓存
+func updateChannelCanary(channelId int, canary *dto.ChannelCanary) error {
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		return err
+	}
+	setting := channel.GetSetting()
+	setting.Canary = canary
+	channel.SetSetting(setting)
+	err = DB.Model(&Channel{}).Where("id = ?", channelId).Update("setting", channel.Setting).Error
+	if err != nil {
+		return err
+	}
+	cacheUpdateChannel(channelId, func(cached *Channel) {
+		cached.Setting = channel.Setting
+	})
+	return nil
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"errors"
+	"math/rand"
+	"one-api/common"
+	"one-api/constant"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// ChannelKey 多密钥渠道中的单个密钥，密钥可以单独禁用并统计各自的已用额度
+type ChannelKey struct {
+	Id           int    `json:"id"`
+	ChannelId    int    `json:"channel_id" gorm:"index"`
+	Key          string `json:"key" gorm:"type:text;not null"`
+	Status       int    `json:"status" gorm:"default:1"`
+	StatusReason string `json:"status_reason"`
+	StatusTime   int64  `json:"status_time" gorm:"bigint"`
+	UsedQuota    int64  `json:"used_quota" gorm:"bigint;default:0"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	ProbeState   string `json:"probe_state" gorm:"type:text"` // 自动禁用后重新探测的计划与记录，见 ChannelProbeState
+}
+
+func (channel *Channel) GetKeyRotation() string {
+	if channel.KeyRotation == nil {
+		return ""
+	}
+	return *channel.KeyRotation
+}
+
+func (channel *Channel) IsMultiKey() bool {
+	return channel.GetKeyRotation() != ""
+}
+
+// GetKeys 解析多密钥渠道的密钥列表，忽略空行与重复的密钥
+func (channel *Channel) GetKeys() []string {
+	keys := make([]string, 0)
+	for _, key := range strings.Split(channel.Key, "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return lo.Uniq(keys)
+}
+
+// SyncChannelKeys 根据渠道的 Key 字段同步密钥表：新增的密钥默认启用，已存在的密钥保留状态与已用额度，移除的密钥被删除
+func (channel *Channel) SyncChannelKeys() error {
+	if !channel.IsMultiKey() {
+		return DB.Where("channel_id = ?", channel.Id).Delete(&ChannelKey{}).Error
+	}
+	var existing []ChannelKey
+	err := DB.Where("channel_id = ?", channel.Id).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	keys := channel.GetKeys()
+	existingKeys := lo.SliceToMap(existing, func(key ChannelKey) (string, bool) { return key.Key, true })
+	newKeys := make([]ChannelKey, 0)
+	for _, key := range keys {
+		if !existingKeys[key] {
+			newKeys = append(newKeys, ChannelKey{
+				ChannelId:   channel.Id,
+				Key:         key,
+				Status:      common.ChannelStatusEnabled,
+				CreatedTime: common.GetTimestamp(),
+			})
+		}
+	}
+	removedIds := lo.FilterMap(existing, func(key ChannelKey, _ int) (int, bool) {
+		return key.Id, !lo.Contains(keys, key.Key)
+	})
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if len(removedIds) > 0 {
+			if err := tx.Where("id in ?", removedIds).Delete(&ChannelKey{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(newKeys) > 0 {
+			if err := tx.Create(&newKeys).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func GetChannelKeys(channelId int) ([]*ChannelKey, error) {
+	var keys []*ChannelKey
+	err := DB.Where("channel_id = ?", channelId).Order("id asc").Find(&keys).Error
+	return keys, err
+}
+
+func GetChannelKeyById(channelId int, id int) (*ChannelKey, error) {
+	key := &ChannelKey{}
+	err := DB.Where("channel_id = ? and id = ?", channelId, id).First(key).Error
+	return key, err
+}
+
+// GetTestChannelKey 返回测试多密钥渠道时使用的密钥：第一个已启用的密钥，没有时使用第一个自动禁用的密钥
+// 所有密钥均被手动禁用时返回错误
+func GetTestChannelKey(channelId int) (*ChannelKey, error) {
+	key := &ChannelKey{}
+	err := DB.Where("channel_id = ? and status in ?", channelId, []int{common.ChannelStatusEnabled, common.ChannelStatusAutoDisabled}).
+		Order("status asc, id asc").First(key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("多密钥渠道没有可测试的密钥")
+	}
+	return key, err
+}
+
+func DeleteChannelKeys(channelIds []int) error {
+	return DB.Where("channel_id in ?", channelIds).Delete(&ChannelKey{}).Error
+}
+
+func getEnabledChannelKeys(channelId int) []*ChannelKey {
+	if common.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		defer channelSyncLock.RUnlock()
+		return lo.Filter(channelId2keys[channelId], func(key *ChannelKey, _ int) bool {
+			return key.Status == common.ChannelStatusEnabled
+		})
+	}
+	var keys []*ChannelKey
+	err := DB.Where("channel_id = ? and status = ?", channelId, common.ChannelStatusEnabled).Order("id asc").Find(&keys).Error
+	if err != nil {
+		common.SysError("failed to get channel keys: " + err.Error())
+	}
+	return keys
+}
+
+// CountEnabledChannelKeys 直接查询数据库，用于判断是否需要禁用整个渠道
+func CountEnabledChannelKeys(channelId int) int64 {
+	var count int64
+	DB.Model(&ChannelKey{}).Where("channel_id = ? and status = ?", channelId, common.ChannelStatusEnabled).Count(&count)
+	return count
+}
+
+var channelKeyCursors sync.Map // channelId -> *atomic.Uint64，轮询游标仅在本节点内有效
+
+// SelectKey 按渠道的轮换方式选择一个已启用的密钥，单密钥渠道返回 nil
+func (channel *Channel) SelectKey() (*ChannelKey, error) {
+	if !channel.IsMultiKey() {
+		return nil, nil
+	}
+	keys := getEnabledChannelKeys(channel.Id)
+	if len(keys) == 0 {
+		return nil, errors.New("no enabled key in multi-key channel")
+	}
+	if channel.GetKeyRotation() == constant.ChannelKeyRotationRandom {
+		return keys[rand.Intn(len(keys))], nil
+	}
+	cursor, _ := channelKeyCursors.LoadOrStore(channel.Id, &atomic.Uint64{})
+	index := cursor.(*atomic.Uint64).Add(1) - 1
+	return keys[index%uint64(len(keys))], nil
+}
+
+// UpdateChannelKeyStatus 更新单个密钥的状态，状态未发生变化时返回 false
+func UpdateChannelKeyStatus(id int, status int, reason string) bool {
+	result := DB.Model(&ChannelKey{}).Where("id = ? and status <> ?", id, status).Updates(map[string]interface{}{
+		"status":        status,
+		"status_reason": reason,
+		"status_time":   common.GetTimestamp(),
+	})
+	if result.Error != nil {
+		common.SysError("failed to update channel key status: " + result.Error.Error())
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	cacheUpdateChannelKeyStatus(id, status)
+	return true
+}
+
+func GetAutoDisabledChannelKeys(channelId int) ([]*ChannelKey, error) {
+	var keys []*ChannelKey
+	err := DB.Where("channel_id = ? and status = ?", channelId, common.ChannelStatusAutoDisabled).Order("id asc").Find(&keys).Error
+	return keys, err
+}
+
+// restoreChannelKeys 渠道启用时如果没有已启用的密钥，重新启用被自动禁用的密钥，手动禁用的密钥保持不变
+// 渠道因为密钥全部被自动禁用而被禁用，重新启用后需要恢复密钥，否则渠道仍然没有可用的密钥
+func restoreChannelKeys(channelIds []int) error {
+	for _, channelId := range channelIds {
+		if CountEnabledChannelKeys(channelId) > 0 {
+			continue
+		}
+		result := DB.Model(&ChannelKey{}).Where("channel_id = ? and status = ?", channelId, common.ChannelStatusAutoDisabled).Updates(map[string]interface{}{
+			"status":        common.ChannelStatusEnabled,
+			"status_reason": "",
+			"status_time":   common.GetTimestamp(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			cacheRestoreChannelKeys(channelId)
+		}
+	}
+	return nil
+}
+
+func cacheRestoreChannelKeys(channelId int) {
+	if !common.MemoryCacheEnabled {
+		return
+	}
+	channelSyncLock.Lock()
+	defer channelSyncLock.Unlock()
+	for _, key := range channelId2keys[channelId] {
+		if key.Status == common.ChannelStatusAutoDisabled {
+			key.Status = common.ChannelStatusEnabled
+		}
+	}
+}
+
+func cacheUpdateChannelKeyStatus(id int, status int) {
+	if !common.MemoryCacheEnabled {
+		return
+	}
+	channelSyncLock.Lock()
+	defer channelSyncLock.Unlock()
+	for _, keys := range channelId2keys {
+		for _, key := range keys {
+			if key.Id == id {
+				key.Status = status
+				return
+			}
+		}
+	}
+}
+
+func UpdateChannelKeyUsedQuota(id int, quota int) {
+	if id == 0 {
+		return
+	}
+	if common.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeChannelKeyUsedQuota, id, quota)
+		return
+	}
+	updateChannelKeyUsedQuota(id, quota)
+}
+
+func updateChannelKeyUsedQuota(id int, quota int) {
+	err := DB.Model(&ChannelKey{}).Where("id = ?", id).Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+	if err != nil {
+		common.SysError("failed to update channel key used quota: " + err.Error())
+	}
+}
+
+// MaskKey 返回用于展示的脱敏密钥
+func (key *ChannelKey) MaskKey() string {
+	if len(key.Key) <= 8 {
+		return strings.Repeat("*", len(key.Key))
+	}
+	return key.Key[:4] + "****" + key.Key[len(key.Key)-4:]
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// ErrRouteConstraintsUnsatisfied 分组下模型的渠道都不满足客户端的路由约束
+var ErrRouteConstraintsUnsatisfied = errors.New("no channel satisfies the route constraints")
+
+// 渠道标签按 Setting 内容缓存解析结果
+var channelLabels sync.Map // setting string -> map[string]string
+
+// GetLabels 返回渠道的标签，未配置时返回 nil
+func (channel *Channel) GetLabels() map[string]string {
+	if channel.Setting == nil || *channel.Setting == "" {
+		return nil
+	}
+	if labels, ok := channelLabels.Load(*channel.Setting); ok {
+		return labels.(map[string]string)
+	}
+	labels := channel.GetSetting().Labels
+	channelLabels.Store(*channel.Setting, labels)
+	return labels
+}
+
+// RouteConstraints 客户端通过 X-Route-Constraints 请求头指定的渠道标签约束
+// 格式为 key=value 并以逗号分隔，同一标签的多个取值用 | 分隔，例如 region=eu|uk,provider=azure
+// 不同标签之间需要同时满足，同一标签的多个取值满足其一即可
+type RouteConstraints map[string][]string
+
+func ParseRouteConstraints(header string) (RouteConstraints, error) {
+	constraints := make(RouteConstraints)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid route constraint %q, expected key=value", part)
+		}
+		for _, v := range strings.Split(value, "|") {
+			if v = strings.TrimSpace(v); v != "" {
+				constraints[key] = append(constraints[key], v)
+			}
+		}
+		if len(constraints[key]) == 0 {
+			return nil, fmt.Errorf("invalid route constraint %q, value is empty", part)
+		}
+	}
+	return constraints, nil
+}
+
+// Match 渠道的标签是否满足约束，没有约束时总是满足
+func (constraints RouteConstraints) Match(channel *Channel) bool {
+	if len(constraints) == 0 {
+		return true
+	}
+	labels := channel.GetLabels()
+	for key, values := range constraints {
+		label, ok := labels[key]
+		if !ok || !lo.Contains(values, label) {
+			return false
+		}
+	}
+	return true
+}
+
+func (constraints RouteConstraints) String() string {
+	parts := make([]string, 0, len(constraints))
+	for key, values := range constraints {
+		parts = append(parts, key+"="+strings.Join(values, "|"))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// GetRouteConstraints 返回请求的路由约束，没有约束时返回 nil
+func GetRouteConstraints(c *gin.Context) RouteConstraints {
+	constraints, _ := common.GetContextKeyType[RouteConstraints](c, constant.ContextKeyRouteConstraints)
+	return constraints
+}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"math/rand"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+)
+
+// channelLatencyStat 渠道+模型的实时首字时间与错误率 EWMA，仅保存在本节点内存中
+type channelLatencyStat struct {
+	ttft          float64 // 毫秒
+	errorRate     float64
+	ttftSamples   int
+	resultSamples int
+}
+
+var channelLatencyStats = make(map[string]*channelLatencyStat)
+var channelLatencyLock sync.RWMutex
+
+func getChannelLatencyStat(channelId int, modelName string) *channelLatencyStat {
+	key := getCircuitBreakerKey(channelId, modelName)
+	stat, ok := channelLatencyStats[key]
+	if !ok {
+		stat = &channelLatencyStat{}
+		channelLatencyStats[key] = stat
+	}
+	return stat
+}
+
+func ewma(current float64, sample float64, samples int, alpha float64) float64 {
+	if samples == 0 {
+		return sample
+	}
+	return alpha*sample + (1-alpha)*current
+}
+
+// RecordChannelTTFT 记录一次请求的首字时间
+func RecordChannelTTFT(channelId int, modelName string, ttft time.Duration) {
+	setting := operation_setting.GetLatencyRoutingSetting()
+	if !setting.Enabled || channelId == 0 {
+		return
+	}
+	channelLatencyLock.Lock()
+	defer channelLatencyLock.Unlock()
+	stat := getChannelLatencyStat(channelId, modelName)
+	stat.ttft = ewma(stat.ttft, float64(ttft.Milliseconds()), stat.ttftSamples, setting.Alpha)
+	stat.ttftSamples++
+}
+
+// RecordChannelOutcome 记录一次请求是否成功，用于计算错误率
+func RecordChannelOutcome(channelId int, modelName string, success bool) {
+	setting := operation_setting.GetLatencyRoutingSetting()
+	if !setting.Enabled || channelId == 0 {
+		return
+	}
+	sample := 0.0
+	if !success {
+		sample = 1
+	}
+	channelLatencyLock.Lock()
+	defer channelLatencyLock.Unlock()
+	stat := getChannelLatencyStat(channelId, modelName)
+	stat.errorRate = ewma(stat.errorRate, sample, stat.resultSamples, setting.Alpha)
+	stat.resultSamples++
+}
+
+// pickLatencyAwareChannel 在同一优先级的渠道中按 权重 × 速度系数 × 健康系数 随机选择
+// 速度系数为同层平均首字时间与该渠道首字时间之比，样本不足的渠道系数为 1
+func pickLatencyAwareChannel(channels []*Channel, modelName string, smoothingFactor int, now time.Time) *Channel {
+	weights := getLatencyAwareWeights(channels, modelName, smoothingFactor, now)
+	totalWeight := 0.0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	randomWeight := rand.Float64() * totalWeight
+	for i, channel := range channels {
+		randomWeight -= weights[i]
+		if randomWeight < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+// getLatencyAwareWeights 返回同一优先级内各渠道的延迟感知权重
+func getLatencyAwareWeights(channels []*Channel, modelName string, smoothingFactor int, now time.Time) []float64 {
+	setting := operation_setting.GetLatencyRoutingSetting()
+	ttfts := make([]float64, len(channels)) // 0 表示样本不足
+	healthFactors := make([]float64, len(channels))
+	totalTTFT, ttftCount := 0.0, 0
+	channelLatencyLock.RLock()
+	for i, channel := range channels {
+		healthFactors[i] = 1
+		stat, ok := channelLatencyStats[getCircuitBreakerKey(channel.Id, modelName)]
+		if !ok {
+			continue
+		}
+		if stat.ttftSamples >= setting.MinSamples && stat.ttft > 0 {
+			ttfts[i] = stat.ttft
+			totalTTFT += stat.ttft
+			ttftCount++
+		}
+		if stat.resultSamples >= setting.MinSamples {
+			healthFactors[i] = max(0.05, (1-stat.errorRate)*(1-stat.errorRate))
+		}
+	}
+	channelLatencyLock.RUnlock()
+
+	weights := make([]float64, len(channels))
+	for i, channel := range channels {
+		speedFactor := 1.0
+		if ttfts[i] > 0 {
+			speedFactor = min(10, max(0.1, totalTTFT/float64(ttftCount)/ttfts[i]))
+		}
+		weights[i] = float64(channel.GetScheduledWeight(now)+smoothingFactor) * speedFactor * healthFactors[i]
+	}
+	return weights
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+
+	"gorm.io/gorm"
+)
+
+// 探测记录中保存的错误信息的最大字符数
+const channelProbeMessageMaxLength = 200
+
+// ChannelProbeRecord 一次探测的结果
+type ChannelProbeRecord struct {
+	Time      int64  `json:"time"`
+	Success   bool   `json:"success"`
+	LatencyMs int64  `json:"latency_ms"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ChannelProbeState 自动禁用渠道的探测计划与记录，保存在渠道 other_info 的 probe 字段中，多密钥渠道的密钥保存在 probe_state 字段中
+// 重新启用后仍然保留，以便查看最近一次禁用的原因与探测记录；再次被禁用时开始新的探测计划
+type ChannelProbeState struct {
+	DisabledAt           int64                `json:"disabled_at"`
+	DisableReason        string               `json:"disable_reason"`
+	IntervalSeconds      int                  `json:"interval_seconds"`
+	NextProbeAt          int64                `json:"next_probe_at"`
+	ConsecutiveSuccesses int                  `json:"consecutive_successes"`
+	Probes               int                  `json:"probes"` // 本次禁用以来的探测次数
+	History              []ChannelProbeRecord `json:"history"`
+}
+
+func GetAutoDisabledChannels() ([]*Channel, error) {
+	var channels []*Channel
+	err := DB.Where("status = ?", common.ChannelStatusAutoDisabled).Find(&channels).Error
+	return channels, err
+}
+
+func (channel *Channel) GetProbeState() *ChannelProbeState {
+	probe, ok := channel.GetOtherInfo()["probe"]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(probe)
+	if err != nil {
+		return nil
+	}
+	state := &ChannelProbeState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		common.SysError("failed to unmarshal channel probe state: " + err.Error())
+		return nil
+	}
+	return state
+}
+
+// GetAutoDisabledKeyChannels 返回存在自动禁用密钥的多密钥渠道，手动禁用的渠道除外
+func GetAutoDisabledKeyChannels() ([]*Channel, error) {
+	var channels []*Channel
+	err := DB.Where("status in ? and id in (?)", []int{common.ChannelStatusEnabled, common.ChannelStatusAutoDisabled},
+		DB.Model(&ChannelKey{}).Select("channel_id").Where("status = ?", common.ChannelStatusAutoDisabled)).Find(&channels).Error
+	return channels, err
+}
+
+// PrepareProbeState 返回自动禁用渠道当前的探测状态，渠道在上次探测计划之后被重新禁用时以禁用时间开始新的探测计划
+func (channel *Channel) PrepareProbeState(setting *operation_setting.ChannelProbeSetting) *ChannelProbeState {
+	info := channel.GetOtherInfo()
+	statusTime, _ := info["status_time"].(float64)
+	reason, _ := info["status_reason"].(string)
+	return prepareProbeState(channel.GetProbeState(), int64(statusTime), reason, setting)
+}
+
+func (key *ChannelKey) GetProbeState() *ChannelProbeState {
+	if key.ProbeState == "" {
+		return nil
+	}
+	state := &ChannelProbeState{}
+	if err := json.Unmarshal([]byte(key.ProbeState), state); err != nil {
+		common.SysError("failed to unmarshal channel key probe state: " + err.Error())
+		return nil
+	}
+	return state
+}
+
+// PrepareProbeState 返回自动禁用密钥当前的探测状态，规则与渠道相同
+func (key *ChannelKey) PrepareProbeState(setting *operation_setting.ChannelProbeSetting) *ChannelProbeState {
+	return prepareProbeState(key.GetProbeState(), key.StatusTime, key.StatusReason, setting)
+}
+
+func prepareProbeState(state *ChannelProbeState, disabledAt int64, reason string, setting *operation_setting.ChannelProbeSetting) *ChannelProbeState {
+	if state == nil {
+		state = &ChannelProbeState{}
+	}
+	if state.NextProbeAt != 0 && state.DisabledAt == disabledAt {
+		return state
+	}
+	if disabledAt == 0 {
+		// 没有记录禁用时间的渠道从现在开始计划
+		disabledAt = common.GetTimestamp()
+	}
+	state.DisabledAt = disabledAt
+	state.DisableReason = reason
+	state.IntervalSeconds = getChannelProbeInitialInterval(setting)
+	state.NextProbeAt = disabledAt + int64(state.IntervalSeconds)
+	state.ConsecutiveSuccesses = 0
+	state.Probes = 0
+	return state
+}
+
+func getChannelProbeInitialInterval(setting *operation_setting.ChannelProbeSetting) int {
+	if setting.InitialIntervalSeconds <= 0 {
+		return 60
+	}
+	return setting.InitialIntervalSeconds
+}
+
+// Record 记录一次探测结果并计划下一次探测，返回是否已连续成功足够的次数
+// 探测成功后以初始间隔继续探测，失败后间隔按倍数增长，不超过最大间隔
+func (state *ChannelProbeState) Record(record ChannelProbeRecord, setting *operation_setting.ChannelProbeSetting) bool {
+	if runes := []rune(record.Message); len(runes) > channelProbeMessageMaxLength {
+		record.Message = string(runes[:channelProbeMessageMaxLength])
+	}
+	state.Probes++
+	state.History = append(state.History, record)
+	if setting.HistorySize > 0 && len(state.History) > setting.HistorySize {
+		state.History = state.History[len(state.History)-setting.HistorySize:]
+	}
+	initialInterval := getChannelProbeInitialInterval(setting)
+	if record.Success {
+		state.ConsecutiveSuccesses++
+		state.IntervalSeconds = initialInterval
+	} else {
+		state.ConsecutiveSuccesses = 0
+		interval := float64(max(state.IntervalSeconds, initialInterval)) * max(setting.Multiplier, 1)
+		if setting.MaxIntervalSeconds > 0 {
+			interval = min(interval, float64(setting.MaxIntervalSeconds))
+		}
+		state.IntervalSeconds = int(interval)
+	}
+	state.NextProbeAt = record.Time + int64(state.IntervalSeconds)
+	return state.ConsecutiveSuccesses >= max(setting.SuccessThreshold, 1)
+}
+
+// SaveProbeState 保存渠道的探测状态，返回渠道当前的状态
+// 在事务中重新读取 other_info 并只替换 probe 字段，探测期间管理员对渠道状态的修改不会被覆盖
+func (channel *Channel) SaveProbeState(state *ChannelProbeState) (int, error) {
+	current := &Channel{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE").Select("id", "status", "other_info").First(current, "id = ?", channel.Id).Error
+		if err != nil {
+			return err
+		}
+		info := current.GetOtherInfo()
+		info["probe"] = state
+		current.SetOtherInfo(info)
+		return tx.Model(&Channel{}).Where("id = ?", channel.Id).Update("other_info", current.OtherInfo).Error
+	})
+	return current.Status, err
+}
+
+// SaveProbeState 保存密钥的探测状态，只更新 probe_state 字段，返回密钥当前的状态
+func (key *ChannelKey) SaveProbeState(state *ChannelProbeState) (int, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+	err = DB.Model(&ChannelKey{}).Where("id = ?", key.Id).Update("probe_state", string(data)).Error
+	if err != nil {
+		return 0, err
+	}
+	current := &ChannelKey{}
+	err = DB.Select("id", "status").First(current, "id = ?", key.Id).Error
+	return current.Status, err
+}
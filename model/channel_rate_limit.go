@@ -0,0 +1,189 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"one-api/common/limiter"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 渠道 RPM/TPM 预算使用令牌桶实现，容量为一分钟的额度，按秒匀速补充
+// 与 ModelRequestRateLimit 一样将桶内单位放大 60 倍以避免小数速率：每个请求或 token 消耗 60 个单位，每秒补充 limit 个单位
+const channelRateLimitWindowSeconds = 60
+
+type channelRateLimitBucket struct {
+	key   string
+	limit int
+}
+
+// getChannelRateLimitBuckets 返回渠道在指定模型下生效的 RPM 与 TPM 令牌桶
+func (channel *Channel) getChannelRateLimitBuckets(modelName string) (rpmBuckets []channelRateLimitBucket, tpmBuckets []channelRateLimitBucket) {
+	if channel.Setting == nil || *channel.Setting == "" {
+		return nil, nil
+	}
+	setting := channel.GetSetting()
+	if setting.RPMLimit > 0 {
+		rpmBuckets = append(rpmBuckets, channelRateLimitBucket{fmt.Sprintf("channel_rate_limit:rpm:%d", channel.Id), setting.RPMLimit})
+	}
+	if setting.TPMLimit > 0 {
+		tpmBuckets = append(tpmBuckets, channelRateLimitBucket{fmt.Sprintf("channel_rate_limit:tpm:%d", channel.Id), setting.TPMLimit})
+	}
+	if modelLimit, ok := setting.ModelRateLimits[modelName]; ok {
+		if modelLimit.RPM > 0 {
+			rpmBuckets = append(rpmBuckets, channelRateLimitBucket{fmt.Sprintf("channel_rate_limit:rpm:%d:%s", channel.Id, modelName), modelLimit.RPM})
+		}
+		if modelLimit.TPM > 0 {
+			tpmBuckets = append(tpmBuckets, channelRateLimitBucket{fmt.Sprintf("channel_rate_limit:tpm:%d:%s", channel.Id, modelName), modelLimit.TPM})
+		}
+	}
+	return rpmBuckets, tpmBuckets
+}
+
+// TryAcquireChannelRateLimit 在向渠道发送请求前检查预算，TPM 预算已耗尽时返回 false，否则占用一次 RPM 额度
+// TPM 只在请求结束后按实际用量扣减（见 ConsumeChannelTokenBudget），这里仅检查桶是否仍有余量
+func TryAcquireChannelRateLimit(channel *Channel, modelName string) bool {
+	rpmBuckets, tpmBuckets := channel.getChannelRateLimitBuckets(modelName)
+	for _, bucket := range tpmBuckets {
+		if !allowChannelRateLimit(bucket, 1, false) {
+			return false
+		}
+	}
+	for i, bucket := range rpmBuckets {
+		if !allowChannelRateLimit(bucket, channelRateLimitWindowSeconds, false) {
+			// 渠道级与模型级的 RPM 桶需要同时占用，其中一个不足时归还已占用的额度
+			for _, acquired := range rpmBuckets[:i] {
+				allowChannelRateLimit(acquired, -channelRateLimitWindowSeconds, false)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// ReleaseChannelRateLimit 归还 TryAcquireChannelRateLimit 占用的 RPM 额度
+// 用于选中后没有实际发出请求就被放弃的渠道，例如写入上下文失败或对冲选择时被排除的渠道
+func ReleaseChannelRateLimit(channel *Channel, modelName string) {
+	if channel == nil {
+		return
+	}
+	rpmBuckets, _ := channel.getChannelRateLimitBuckets(modelName)
+	for _, bucket := range rpmBuckets {
+		allowChannelRateLimit(bucket, -channelRateLimitWindowSeconds, false)
+	}
+}
+
+// ConsumeChannelTokenBudget 请求结束后按实际 token 用量扣减渠道 TPM 预算，允许透支，透支期间渠道不会被选中
+func ConsumeChannelTokenBudget(channelId int, modelName string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		return
+	}
+	_, tpmBuckets := channel.getChannelRateLimitBuckets(modelName)
+	for _, bucket := range tpmBuckets {
+		allowChannelRateLimit(bucket, int64(tokens)*channelRateLimitWindowSeconds, true)
+	}
+}
+
+func allowChannelRateLimit(bucket channelRateLimitBucket, requested int64, overdraft bool) bool {
+	capacity := int64(bucket.limit) * channelRateLimitWindowSeconds
+	rate := int64(bucket.limit)
+	if common.RedisEnabled {
+		ctx := context.Background()
+		opts := []limiter.Option{
+			limiter.WithCapacity(capacity),
+			limiter.WithRate(rate),
+			limiter.WithRequested(requested),
+		}
+		if overdraft {
+			opts = append(opts, limiter.WithOverdraft())
+		}
+		allowed, err := limiter.New(ctx, common.RDB).Allow(ctx, bucket.key, opts...)
+		if err != nil {
+			// Redis 异常时不因预算检查拒绝请求
+			common.SysError("failed to check channel rate limit: " + err.Error())
+			return true
+		}
+		return allowed
+	}
+	return allowChannelRateLimitInMemory(bucket.key, requested, rate, capacity, overdraft)
+}
+
+type memoryTokenBucket struct {
+	tokens   int64
+	lastTime int64
+}
+
+var channelRateLimitBuckets = make(map[string]*memoryTokenBucket)
+var channelRateLimitLock sync.Mutex
+
+// allowChannelRateLimitInMemory 未启用 Redis 时的本地令牌桶，语义与 rate_limit.lua 一致
+func allowChannelRateLimitInMemory(key string, requested int64, rate int64, capacity int64, overdraft bool) bool {
+	now := time.Now().Unix()
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	bucket, ok := channelRateLimitBuckets[key]
+	if !ok {
+		bucket = &memoryTokenBucket{tokens: capacity, lastTime: now}
+		channelRateLimitBuckets[key] = bucket
+	} else {
+		bucket.tokens = min(capacity, bucket.tokens+(now-bucket.lastTime)*rate)
+		bucket.lastTime = now
+	}
+	if bucket.tokens >= requested {
+		// requested 为负数时表示归还额度，归还后不超过桶容量
+		bucket.tokens = min(capacity, bucket.tokens-requested)
+		return true
+	}
+	if overdraft {
+		bucket.tokens -= requested
+	}
+	return false
+}
+
+// HasChannelRateLimitBudget 只检查渠道预算是否仍有余量而不占用额度，用于路由诊断
+func HasChannelRateLimitBudget(channel *Channel, modelName string) bool {
+	rpmBuckets, tpmBuckets := channel.getChannelRateLimitBuckets(modelName)
+	for _, bucket := range tpmBuckets {
+		if peekChannelRateLimit(bucket) < 1 {
+			return false
+		}
+	}
+	for _, bucket := range rpmBuckets {
+		if peekChannelRateLimit(bucket) < channelRateLimitWindowSeconds {
+			return false
+		}
+	}
+	return true
+}
+
+// peekChannelRateLimit 返回令牌桶当前的余量
+func peekChannelRateLimit(bucket channelRateLimitBucket) int64 {
+	capacity := int64(bucket.limit) * channelRateLimitWindowSeconds
+	rate := int64(bucket.limit)
+	now := time.Now().Unix()
+	if common.RedisEnabled {
+		values, err := common.RDB.HMGet(context.Background(), bucket.key, "tokens", "last_time").Result()
+		if err != nil || len(values) != 2 || values[0] == nil || values[1] == nil {
+			return capacity
+		}
+		tokens, err1 := strconv.ParseFloat(fmt.Sprint(values[0]), 64)
+		lastTime, err2 := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+		if err1 != nil || err2 != nil {
+			return capacity
+		}
+		return min(capacity, int64(tokens)+(now-lastTime)*rate)
+	}
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	memoryBucket, ok := channelRateLimitBuckets[bucket.key]
+	if !ok {
+		return capacity
+	}
+	return min(capacity, memoryBucket.tokens+(now-memoryBucket.lastTime)*rate)
+}
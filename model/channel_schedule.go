@@ -0,0 +1,74 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/setting"
+	"sync"
+	"time"
+)
+
+// 渠道时间计划的解析结果按 Setting 内容缓存，Setting 变化后自然使用新的计划
+var channelScheduleRules sync.Map // setting string -> *setting.ChannelScheduleRule，未配置计划时为 nil
+
+func (channel *Channel) getScheduleRule() *setting.ChannelScheduleRule {
+	if channel.Setting == nil || *channel.Setting == "" {
+		return nil
+	}
+	if rule, ok := channelScheduleRules.Load(*channel.Setting); ok {
+		return rule.(*setting.ChannelScheduleRule)
+	}
+	var rule *setting.ChannelScheduleRule
+	if schedule := channel.GetSetting().Schedule; schedule != nil {
+		var err error
+		rule, err = setting.CompileChannelSchedule(schedule)
+		if err != nil {
+			common.SysError("failed to compile channel schedule: " + err.Error())
+		}
+	}
+	channelScheduleRules.Store(*channel.Setting, rule)
+	return rule
+}
+
+// getScheduleRules 返回渠道自身与所属标签的时间计划，渠道自身的在前
+func (channel *Channel) getScheduleRules() []*setting.ChannelScheduleRule {
+	var rules []*setting.ChannelScheduleRule
+	if rule := channel.getScheduleRule(); rule != nil {
+		rules = append(rules, rule)
+	}
+	if tag := channel.GetTag(); tag != "" {
+		if rule := setting.GetTagScheduleRule(tag); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// IsScheduledAvailable 渠道自身与所属标签的时间计划都允许在当前时间使用该渠道
+func (channel *Channel) IsScheduledAvailable(now time.Time) bool {
+	for _, rule := range channel.getScheduleRules() {
+		if !rule.IsAvailable(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetScheduledPriority 返回当前时间生效的优先级，渠道自身的覆盖优先于标签的覆盖
+func (channel *Channel) GetScheduledPriority(now time.Time) int64 {
+	for _, rule := range channel.getScheduleRules() {
+		if priority, _ := rule.GetOverride(now); priority != nil {
+			return *priority
+		}
+	}
+	return channel.GetPriority()
+}
+
+// GetScheduledWeight 返回当前时间生效的权重，渠道自身的覆盖优先于标签的覆盖
+func (channel *Channel) GetScheduledWeight(now time.Time) int {
+	for _, rule := range channel.getScheduleRules() {
+		if _, weight := rule.GetOverride(now); weight != nil {
+			return int(*weight)
+		}
+	}
+	return channel.GetWeight()
+}
@@ -0,0 +1,237 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitBreakerClosed   = "closed"
+	CircuitBreakerOpen     = "open"
+	CircuitBreakerHalfOpen = "half_open"
+)
+
+// circuitBreaker 单个渠道（或渠道+模型）的熔断状态，仅保存在本节点内存中
+type circuitBreaker struct {
+	state               string
+	consecutiveFailures int
+	halfOpenSuccesses   int
+	windowStart         time.Time
+	windowRequests      int
+	windowFailures      int
+	openedAt            time.Time
+}
+
+// CircuitBreakerStatus 渠道列表中展示的熔断状态
+type CircuitBreakerStatus struct {
+	State               string            `json:"state"`
+	ConsecutiveFailures int               `json:"consecutive_failures"`
+	WindowRequests      int               `json:"window_requests"`
+	WindowFailures      int               `json:"window_failures"`
+	OpenedAt            int64             `json:"opened_at,omitempty"`
+	Models              map[string]string `json:"models,omitempty"` // 非关闭状态的渠道+模型熔断
+}
+
+var circuitBreakers = make(map[string]*circuitBreaker)
+var circuitBreakerLock sync.Mutex
+
+func getCircuitBreakerKey(channelId int, modelName string) string {
+	if modelName == "" {
+		return strconv.Itoa(channelId)
+	}
+	return fmt.Sprintf("%d|%s", channelId, modelName)
+}
+
+// refreshState 熔断时间结束后进入半开状态
+func (breaker *circuitBreaker) refreshState(setting *operation_setting.CircuitBreakerSetting, now time.Time) {
+	if breaker.state == CircuitBreakerOpen && now.Sub(breaker.openedAt) >= time.Duration(setting.OpenSeconds)*time.Second {
+		breaker.state = CircuitBreakerHalfOpen
+		breaker.halfOpenSuccesses = 0
+	}
+}
+
+func (breaker *circuitBreaker) allow() bool {
+	switch breaker.state {
+	case CircuitBreakerOpen:
+		return false
+	case CircuitBreakerHalfOpen:
+		// 半开状态只放行少量流量用于探测
+		return rand.Intn(100) < operation_setting.GetCircuitBreakerSetting().HalfOpenPercent
+	default:
+		return true
+	}
+}
+
+func (breaker *circuitBreaker) open(now time.Time) {
+	breaker.state = CircuitBreakerOpen
+	breaker.openedAt = now
+	breaker.consecutiveFailures = 0
+	breaker.windowRequests = 0
+	breaker.windowFailures = 0
+}
+
+// record 记录一次请求结果，返回状态是否发生变化
+func (breaker *circuitBreaker) record(setting *operation_setting.CircuitBreakerSetting, success bool, now time.Time) bool {
+	breaker.refreshState(setting, now)
+	switch breaker.state {
+	case CircuitBreakerOpen:
+		return false
+	case CircuitBreakerHalfOpen:
+		if !success {
+			breaker.open(now)
+			return true
+		}
+		breaker.halfOpenSuccesses++
+		if breaker.halfOpenSuccesses >= setting.HalfOpenSuccesses {
+			*breaker = circuitBreaker{state: CircuitBreakerClosed, windowStart: now}
+			return true
+		}
+		return false
+	}
+
+	if now.Sub(breaker.windowStart) >= time.Duration(setting.WindowSeconds)*time.Second {
+		breaker.windowStart = now
+		breaker.windowRequests = 0
+		breaker.windowFailures = 0
+	}
+	breaker.windowRequests++
+	if success {
+		breaker.consecutiveFailures = 0
+		return false
+	}
+	breaker.consecutiveFailures++
+	breaker.windowFailures++
+	if setting.ConsecutiveFailures > 0 && breaker.consecutiveFailures >= setting.ConsecutiveFailures {
+		breaker.open(now)
+		return true
+	}
+	if setting.ErrorRateThreshold > 0 && breaker.windowRequests >= setting.MinRequests &&
+		float64(breaker.windowFailures)/float64(breaker.windowRequests) >= setting.ErrorRateThreshold {
+		breaker.open(now)
+		return true
+	}
+	return false
+}
+
+// IsCircuitBreakerAllowed 渠道以及渠道+模型的熔断器是否都允许请求通过
+func IsCircuitBreakerAllowed(channelId int, modelName string) bool {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled {
+		return true
+	}
+	now := time.Now()
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	for _, key := range []string{getCircuitBreakerKey(channelId, ""), getCircuitBreakerKey(channelId, modelName)} {
+		breaker, ok := circuitBreakers[key]
+		if !ok {
+			continue
+		}
+		breaker.refreshState(setting, now)
+		if !breaker.allow() {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordCircuitBreakerResult 记录渠道请求结果，同时更新渠道与渠道+模型两级熔断器
+func RecordCircuitBreakerResult(channelId int, modelName string, success bool) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || channelId == 0 {
+		return
+	}
+	now := time.Now()
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	for _, key := range []string{getCircuitBreakerKey(channelId, ""), getCircuitBreakerKey(channelId, modelName)} {
+		breaker, ok := circuitBreakers[key]
+		if !ok {
+			if success {
+				continue
+			}
+			breaker = &circuitBreaker{state: CircuitBreakerClosed, windowStart: now}
+			circuitBreakers[key] = breaker
+		}
+		if breaker.record(setting, success, now) {
+			common.SysLog(fmt.Sprintf("circuit breaker %s changed to %s", key, breaker.state))
+		}
+	}
+}
+
+// GetChannelCircuitBreakerStatus 获取渠道的熔断状态，未记录过失败的渠道返回 nil
+func GetChannelCircuitBreakerStatus(channelId int) *CircuitBreakerStatus {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now()
+	prefix := strconv.Itoa(channelId) + "|"
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	var status *CircuitBreakerStatus
+	if breaker, ok := circuitBreakers[strconv.Itoa(channelId)]; ok {
+		breaker.refreshState(setting, now)
+		status = &CircuitBreakerStatus{
+			State:               breaker.state,
+			ConsecutiveFailures: breaker.consecutiveFailures,
+			WindowRequests:      breaker.windowRequests,
+			WindowFailures:      breaker.windowFailures,
+		}
+		if breaker.state != CircuitBreakerClosed {
+			status.OpenedAt = breaker.openedAt.Unix()
+		}
+	}
+	for key, breaker := range circuitBreakers {
+		if len(key) <= len(prefix) || key[:len(prefix)] != prefix {
+			continue
+		}
+		breaker.refreshState(setting, now)
+		if breaker.state == CircuitBreakerClosed {
+			continue
+		}
+		if status == nil {
+			status = &CircuitBreakerStatus{State: CircuitBreakerClosed}
+		}
+		if status.Models == nil {
+			status.Models = make(map[string]string)
+		}
+		status.Models[key[len(prefix):]] = breaker.state
+	}
+	return status
+}
+
+// FillChannelCircuitBreakers 为渠道列表填充熔断状态
+func FillChannelCircuitBreakers(channels []*Channel) {
+	for _, channel := range channels {
+		channel.CircuitBreaker = GetChannelCircuitBreakerStatus(channel.Id)
+	}
+}
+
+// GetCircuitBreakerState 返回渠道与渠道+模型两级熔断器中最严格的状态，不影响半开状态的放行
+func GetCircuitBreakerState(channelId int, modelName string) string {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled {
+		return CircuitBreakerClosed
+	}
+	now := time.Now()
+	state := CircuitBreakerClosed
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	for _, key := range []string{getCircuitBreakerKey(channelId, ""), getCircuitBreakerKey(channelId, modelName)} {
+		breaker, ok := circuitBreakers[key]
+		if !ok {
+			continue
+		}
+		breaker.refreshState(setting, now)
+		if breaker.state == CircuitBreakerOpen {
+			return CircuitBreakerOpen
+		}
+		if breaker.state == CircuitBreakerHalfOpen {
+			state = CircuitBreakerHalfOpen
+		}
+	}
+	return state
+}
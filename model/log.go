@@ -17,7 +17,7 @@ import (
 type Log struct {
 	Id               int    `json:"id" gorm:"index:idx_created_at_id,priority:1"`
 	UserId           int    `json:"user_id" gorm:"index"`
-	CreatedAt        int64  `json:"created_at" gorm:"bigint;index:idx_created_at_id,priority:2;index:idx_created_at_type"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index:idx_created_at_id,priority:2;index:idx_created_at_type;index:idx_prompt_affinity_created_at,priority:2"`
 	Type             int    `json:"type" gorm:"index:idx_created_at_type"`
 	Content          string `json:"content"`
 	Username         string `json:"username" gorm:"index;index:index_username_model_name,priority:2;default:''"`
@@ -34,6 +34,8 @@ type Log struct {
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	Other            string `json:"other"`
+	CacheTokens      int    `json:"cache_tokens" gorm:"default:0"`
+	PromptAffinity   bool   `json:"prompt_affinity" gorm:"default:false;index:idx_prompt_affinity_created_at,priority:1"` // 是否经提示词亲和路由选择渠道
 }
 
 const (
@@ -156,6 +158,8 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 	}
 	username := c.GetString("username")
 	otherStr := common.MapToJsonStr(params.Other)
+	cacheTokens, _ := params.Other["cache_tokens"].(int)
+	promptAffinity, _ := params.Other["prompt_affinity"].(bool)
 	// 判断是否需要记录 IP
 	needRecordIp := false
 	if settingMap, err := GetUserSetting(userId, false); err == nil {
@@ -185,7 +189,9 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 			return ""
 		}(),
-		Other: otherStr,
+		Other:          otherStr,
+		CacheTokens:    cacheTokens,
+		PromptAffinity: promptAffinity,
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -387,6 +393,49 @@ func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelNa
 	return token
 }
 
+// PromptAffinityStat 经提示词亲和路由的请求在某个渠道上的提示词缓存命中情况
+type PromptAffinityStat struct {
+	ChannelId    int     `json:"channel_id"`
+	Requests     int     `json:"requests"`
+	PromptTokens int     `json:"prompt_tokens"`
+	CacheTokens  int     `json:"cache_tokens"`
+	HitRate      float64 `json:"hit_rate"` // 缓存命中的 token 占提示词 token 的比例
+}
+
+// GetPromptAffinityStats 按渠道汇总时间范围内经提示词亲和路由的消费日志中的缓存 token，channelId 为 0 的一项为全部渠道的合计
+// 按日志的 prompt_affinity 标记在数据库中聚合，不读取日志明细
+func GetPromptAffinityStats(startTimestamp int64, endTimestamp int64, modelName string) ([]*PromptAffinityStat, error) {
+	tx := LOG_DB.Model(&Log{}).
+		Select("channel_id, count(*) as requests, sum(prompt_tokens) as prompt_tokens, sum(cache_tokens) as cache_tokens").
+		Where("prompt_affinity = ? and type = ?", true, LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	var channelStats []*PromptAffinityStat
+	if err := tx.Group("channel_id").Order("channel_id").Scan(&channelStats).Error; err != nil {
+		return nil, err
+	}
+	total := &PromptAffinityStat{}
+	for _, stat := range channelStats {
+		total.Requests += stat.Requests
+		total.PromptTokens += stat.PromptTokens
+		total.CacheTokens += stat.CacheTokens
+	}
+	stats := append([]*PromptAffinityStat{total}, channelStats...)
+	for _, stat := range stats {
+		if stat.PromptTokens > 0 {
+			stat.HitRate = float64(stat.CacheTokens) / float64(stat.PromptTokens)
+		}
+	}
+	return stats, nil
+}
+
 func DeleteOldLog(ctx context.Context, targetTimestamp int64, limit int) (int64, error) {
 	var total int64 = 0
 
@@ -195,7 +195,11 @@ func InitDB() (err error) {
 			//_, _ = sqlDB.Exec("ALTER TABLE channels MODIFY model_mapping TEXT;") // TODO: delete this line when most users have upgraded
 		}
 		common.SysLog("database migration started")
+		policyTableExists := DB.Migrator().HasTable(&Policy{})
 		err = migrateDB()
+		if err == nil && !policyTableExists {
+			createDefaultPolicies()
+		}
 		return err
 	} else {
 		common.FatalLog(err)
@@ -257,6 +261,9 @@ func migrateDB() error {
 		&QuotaData{},
 		&Task{},
 		&Setup{},
+		&Policy{},
+		&ChannelKey{},
+		&ShadowLog{},
 	)
 	if err != nil {
 		return err
@@ -266,7 +273,7 @@ func migrateDB() error {
 
 func migrateDBFast() error {
 	var wg sync.WaitGroup
-	errChan := make(chan error, 12) // Buffer size matches number of migrations
+	errChan := make(chan error, 15) // Buffer size matches number of migrations
 
 	migrations := []struct {
 		model interface{}
@@ -284,6 +291,9 @@ func migrateDBFast() error {
 		{&QuotaData{}, "QuotaData"},
 		{&Task{}, "Task"},
 		{&Setup{}, "Setup"},
+		{&Policy{}, "Policy"},
+		{&ChannelKey{}, "ChannelKey"},
+		{&ShadowLog{}, "ShadowLog"},
 	}
 
 	for _, m := range migrations {
@@ -315,6 +325,9 @@ func migrateLOGDB() error {
 	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
 		return err
 	}
+	if err = LOG_DB.AutoMigrate(&ShadowLog{}); err != nil {
+		return err
+	}
 	return nil
 }
 
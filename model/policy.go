@@ -0,0 +1,224 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/setting/operation_setting"
+	"strconv"
+
+	"github.com/samber/lo"
+)
+
+// Policy 虚拟策略模型，请求该名称时会按策略解析为其中一个成员模型
+type Policy struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(255);uniqueIndex"`
+	Strategy    string `json:"strategy" gorm:"type:varchar(32);default:'weighted'"`
+	Members     string `json:"members" gorm:"type:text"` // JSON 数组，见 PolicyMember
+	Status      int    `json:"status" gorm:"default:1"`
+	Description string `json:"description"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+type PolicyMember struct {
+	Model  string `json:"model"`
+	Weight int    `json:"weight"`
+}
+
+func (policy *Policy) GetMembers() []PolicyMember {
+	members := make([]PolicyMember, 0)
+	if policy.Members == "" {
+		return members
+	}
+	err := json.Unmarshal([]byte(policy.Members), &members)
+	if err != nil {
+		common.SysError("failed to unmarshal policy members: " + err.Error())
+	}
+	return members
+}
+
+func (policy *Policy) SetMembers(members []PolicyMember) {
+	membersBytes, err := json.Marshal(members)
+	if err != nil {
+		common.SysError("failed to marshal policy members: " + err.Error())
+		return
+	}
+	policy.Members = string(membersBytes)
+}
+
+func (policy *Policy) GetMemberModels() []string {
+	return lo.Map(policy.GetMembers(), func(m PolicyMember, _ int) string { return m.Model })
+}
+
+// ValidateMembers 校验成员配置，成员不能为空，模型名不能重复且权重不能为负
+func (policy *Policy) ValidateMembers() error {
+	var members []PolicyMember
+	if policy.Members == "" {
+		return errors.New("策略成员不能为空")
+	}
+	if err := json.Unmarshal([]byte(policy.Members), &members); err != nil {
+		return fmt.Errorf("策略成员格式错误：%s", err.Error())
+	}
+	if len(members) == 0 {
+		return errors.New("策略成员不能为空")
+	}
+	seen := make(map[string]bool)
+	for _, member := range members {
+		if member.Model == "" {
+			return errors.New("成员模型名称不能为空")
+		}
+		if member.Model == policy.Name {
+			return errors.New("成员模型不能是策略本身")
+		}
+		if seen[member.Model] {
+			return fmt.Errorf("成员模型重复：%s", member.Model)
+		}
+		if member.Weight < 0 {
+			return fmt.Errorf("成员模型 %s 的权重不能为负数", member.Model)
+		}
+		seen[member.Model] = true
+	}
+	return nil
+}
+
+// Resolve 根据策略类型从成员中选出一个真实模型
+// cheapest 策略依赖分组与定价信息，由 service.ResolveVirtualPolicyModel 处理
+func (policy *Policy) Resolve() string {
+	members := policy.GetMembers()
+	if len(members) == 0 {
+		return ""
+	}
+	switch policy.Strategy {
+	case constant.PolicyStrategyPriority:
+		return members[0].Model
+	case constant.PolicyStrategyDegrade:
+		if operation_setting.GetGrayLogicSetting().IsOverloaded() {
+			// 高负载时使用最后一个（较低性能的）成员
+			return members[len(members)-1].Model
+		}
+		return members[0].Model
+	default:
+		return pickWeightedPolicyMember(members)
+	}
+}
+
+func pickWeightedPolicyMember(members []PolicyMember) string {
+	totalWeight := 0
+	for _, member := range members {
+		totalWeight += member.Weight
+	}
+	if totalWeight <= 0 {
+		return members[rand.Intn(len(members))].Model
+	}
+	randomWeight := rand.Intn(totalWeight)
+	for _, member := range members {
+		randomWeight -= member.Weight
+		if randomWeight < 0 {
+			return member.Model
+		}
+	}
+	return members[len(members)-1].Model
+}
+
+func GetAllPolicies(startIdx int, num int) (policies []*Policy, total int64, err error) {
+	err = DB.Model(&Policy{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&policies).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return policies, total, nil
+}
+
+func SearchPolicies(keyword string, startIdx int, num int) (policies []*Policy, total int64, err error) {
+	query := DB.Model(&Policy{})
+	if id, err := strconv.Atoi(keyword); err == nil {
+		query = query.Where("id = ? OR name LIKE ?", id, keyword+"%")
+	} else {
+		query = query.Where("name LIKE ?", keyword+"%")
+	}
+	err = query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&policies).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return policies, total, nil
+}
+
+func GetPolicyById(id int) (*Policy, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	policy := Policy{Id: id}
+	err := DB.First(&policy, "id = ?", id).Error
+	return &policy, err
+}
+
+func GetEnabledPolicyByName(name string) (*Policy, error) {
+	policy := Policy{}
+	err := DB.First(&policy, "name = ? and status = ?", name, common.PolicyStatusEnabled).Error
+	return &policy, err
+}
+
+func IsPolicyNameExists(name string, excludeId int) bool {
+	var count int64
+	DB.Model(&Policy{}).Where("name = ? and id != ?", name, excludeId).Count(&count)
+	return count > 0
+}
+
+func (policy *Policy) Insert() error {
+	policy.CreatedTime = common.GetTimestamp()
+	policy.UpdatedTime = policy.CreatedTime
+	return DB.Create(policy).Error
+}
+
+// Update Make sure your policy's fields is completed, because this will update zero values
+func (policy *Policy) Update() error {
+	policy.UpdatedTime = common.GetTimestamp()
+	return DB.Model(policy).Select("name", "strategy", "members", "status", "description", "updated_time").Updates(policy).Error
+}
+
+func DeletePolicyById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Delete(&Policy{}, "id = ?", id).Error
+}
+
+// createDefaultPolicies 首次创建策略表时写入原先内置的四个虚拟策略模型
+func createDefaultPolicies() {
+	defaults := []struct {
+		name        string
+		strategy    string
+		description string
+		members     []PolicyMember
+	}{
+		{"policy-a-ha", constant.PolicyStrategyWeighted, "高可用策略", []PolicyMember{{"gpt-4o", 50}, {"claude-3-sonnet-20240229", 50}}},
+		{"policy-b-cost", constant.PolicyStrategyWeighted, "成本效益策略", []PolicyMember{{"gemini-1.5-flash-latest", 80}, {"claude-3-haiku-20240307", 20}}},
+		{"policy-c-quality", constant.PolicyStrategyPriority, "质量优先策略", []PolicyMember{{"gpt-4o", 100}}},
+		{"policy-d-degrade", constant.PolicyStrategyDegrade, "负载降级策略", []PolicyMember{{"gpt-4o", 100}, {"gpt-3.5-turbo-0125", 0}}},
+	}
+	for _, d := range defaults {
+		policy := Policy{
+			Name:        d.name,
+			Strategy:    d.strategy,
+			Status:      common.PolicyStatusEnabled,
+			Description: d.description,
+		}
+		policy.SetMembers(d.members)
+		if err := policy.Insert(); err != nil {
+			common.SysError("failed to create default policy " + d.name + ": " + err.Error())
+		}
+	}
+	common.SysLog("default virtual policies created")
+}
@@ -0,0 +1,237 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"sort"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// 渠道被排除出候选的原因
+const (
+	RouteExcludeManuallyDisabled = "manually_disabled"
+	RouteExcludeAutoDisabled     = "auto_disabled"
+	RouteExcludeAbilityDisabled  = "ability_disabled"      // 渠道已启用但该分组与模型的能力未启用
+	RouteExcludeExactPreferred   = "exact_match_preferred" // 存在精确列出模型的渠道时不使用模型通配符匹配的渠道
+	RouteExcludeNotInCache       = "not_in_cache"          // 内存缓存尚未同步该渠道
+	RouteExcludeRouteConstraints = "route_constraints"
+	RouteExcludeOutOfSchedule    = "out_of_schedule"
+	RouteExcludeNoEnabledKey     = "no_enabled_key"
+	RouteExcludeSaturated        = "saturated"
+	RouteExcludeCircuitOpen      = "circuit_open"
+	RouteExcludeRateLimited      = "rate_limited"
+)
+
+// RouteExplainCandidate 路由诊断中的一个候选渠道
+type RouteExplainCandidate struct {
+	ChannelId int    `json:"channel_id"`
+	Name      string `json:"name"`
+	Type      int    `json:"type"`
+	Status    int    `json:"status"`
+	// 渠道列出的模型名，通过模型通配符匹配时为模型通配符
+	MatchedModel string `json:"matched_model"`
+	Priority     int64  `json:"priority"` // 生效的优先级与权重，包含时间计划的覆盖
+	Weight       int    `json:"weight"`
+	Included     bool   `json:"included"`
+	// 被排除的全部原因
+	Reasons []string `json:"reasons,omitempty"`
+	// 不影响候选资格的提示，例如熔断器处于半开状态时只会放行部分请求
+	Notes []string `json:"notes,omitempty"`
+	// 所在优先级的序号，0 为最高优先级，被排除的渠道为 -1
+	Tier int `json:"tier"`
+	// 灰度渠道当前的放量比例，即在所在优先级中承接的流量百分比
+	CanaryPercent float64 `json:"canary_percent,omitempty"`
+	// 首次选择渠道时被选中的概率，重试时从更低的优先级选择
+	Probability float64 `json:"probability"`
+}
+
+type RouteExplainTier struct {
+	Priority   int64 `json:"priority"`
+	ChannelIds []int `json:"channel_ids"`
+}
+
+type RouteExplainGroup struct {
+	Group      string                   `json:"group"`
+	Model      string                   `json:"model"`
+	Candidates []*RouteExplainCandidate `json:"candidates"`
+	Tiers      []*RouteExplainTier      `json:"tiers"`
+}
+
+// ExplainChannelRoute 列出分组下模型的全部候选渠道（包括已禁用的渠道）以及每个渠道参与或不参与选择的原因
+// 按与渠道选择相同的规则划分优先级，并计算最高优先级内各渠道被选中的概率；诊断不会占用渠道的预算
+func ExplainChannelRoute(group string, modelName string, constraints RouteConstraints) (*RouteExplainGroup, error) {
+	explain := &RouteExplainGroup{Group: group, Model: modelName, Candidates: []*RouteExplainCandidate{}, Tiers: []*RouteExplainTier{}}
+	patterns, err := getAbilityModelPatterns(group, modelName, false)
+	if err != nil {
+		return nil, err
+	}
+	models := append([]string{modelName}, patterns...)
+	var abilities []Ability
+	err = DB.Where(commonGroupCol+" = ? and model in ?", group, models).Find(&abilities).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(abilities) == 0 {
+		return explain, nil
+	}
+	// 渠道同时列出精确的模型名与模型通配符时只保留最先匹配的一条能力
+	sort.SliceStable(abilities, func(i, j int) bool {
+		return lo.IndexOf(models, abilities[i].Model) < lo.IndexOf(models, abilities[j].Model)
+	})
+	abilities = lo.UniqBy(abilities, func(ability Ability) int { return ability.ChannelId })
+	var channels []*Channel
+	err = DB.Where("id in ?", lo.Map(abilities, func(ability Ability, _ int) int { return ability.ChannelId })).Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	channelMap := lo.SliceToMap(channels, func(channel *Channel) (int, *Channel) { return channel.Id, channel })
+	exactMatched := lo.ContainsBy(abilities, func(ability Ability) bool {
+		channel, ok := channelMap[ability.ChannelId]
+		return ability.Model == modelName && ability.Enabled && ok && channel.Status == common.ChannelStatusEnabled
+	})
+
+	cachedChannels := make(map[int]*Channel)
+	if common.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		for _, channel := range getGroupModelChannels(group, modelName) {
+			cachedChannels[channel.Id] = channel
+		}
+		channelSyncLock.RUnlock()
+	}
+
+	now := time.Now()
+	included := make([]*Channel, 0)
+	candidateMap := make(map[int]*RouteExplainCandidate)
+	for _, ability := range abilities {
+		channel, ok := channelMap[ability.ChannelId]
+		if !ok {
+			continue
+		}
+		if cached, ok := cachedChannels[channel.Id]; ok {
+			// 与选择渠道时使用同一份渠道数据
+			channel = cached
+		}
+		candidate := &RouteExplainCandidate{
+			ChannelId:    channel.Id,
+			Name:         channel.Name,
+			Type:         channel.Type,
+			Status:       channel.Status,
+			MatchedModel: ability.Model,
+			Weight:       int(ability.Weight),
+			Tier:         -1,
+		}
+		if ability.Priority != nil {
+			candidate.Priority = *ability.Priority
+		}
+		if common.MemoryCacheEnabled {
+			candidate.Priority = channel.GetScheduledPriority(now)
+			candidate.Weight = channel.GetScheduledWeight(now)
+		}
+		switch channel.Status {
+		case common.ChannelStatusEnabled:
+		case common.ChannelStatusAutoDisabled:
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeAutoDisabled)
+		default:
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeManuallyDisabled)
+		}
+		if canary := channel.GetCanary(); canary != nil {
+			candidate.CanaryPercent = canary.Percent
+		}
+		if channel.Status == common.ChannelStatusEnabled && !ability.Enabled {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeAbilityDisabled)
+		}
+		if exactMatched && ability.Model != modelName {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeExactPreferred)
+		} else if common.MemoryCacheEnabled && channel.Status == common.ChannelStatusEnabled && cachedChannels[channel.Id] == nil {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeNotInCache)
+		}
+		if !constraints.Match(channel) {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeRouteConstraints)
+		}
+		if !channel.IsScheduledAvailable(now) {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeOutOfSchedule)
+		}
+		if channel.IsMultiKey() && len(getEnabledChannelKeys(channel.Id)) == 0 {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeNoEnabledKey)
+		}
+		if channel.IsSaturated() {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeSaturated)
+		}
+		switch GetCircuitBreakerState(channel.Id, modelName) {
+		case CircuitBreakerOpen:
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeCircuitOpen)
+		case CircuitBreakerHalfOpen:
+			candidate.Notes = append(candidate.Notes, "circuit_half_open")
+		}
+		if !HasChannelRateLimitBudget(channel, modelName) {
+			candidate.Reasons = append(candidate.Reasons, RouteExcludeRateLimited)
+		}
+		candidate.Included = len(candidate.Reasons) == 0
+		if candidate.Included {
+			included = append(included, channel)
+		}
+		candidateMap[channel.Id] = candidate
+		explain.Candidates = append(explain.Candidates, candidate)
+	}
+
+	// 按优先级从高到低划分层级
+	tierMap := make(map[int64]*RouteExplainTier)
+	for _, channel := range included {
+		candidate := candidateMap[channel.Id]
+		tier, ok := tierMap[candidate.Priority]
+		if !ok {
+			tier = &RouteExplainTier{Priority: candidate.Priority}
+			tierMap[candidate.Priority] = tier
+			explain.Tiers = append(explain.Tiers, tier)
+		}
+		tier.ChannelIds = append(tier.ChannelIds, channel.Id)
+	}
+	sort.Slice(explain.Tiers, func(i, j int) bool { return explain.Tiers[i].Priority > explain.Tiers[j].Priority })
+	for i, tier := range explain.Tiers {
+		for _, channelId := range tier.ChannelIds {
+			candidateMap[channelId].Tier = i
+		}
+	}
+	sort.SliceStable(explain.Candidates, func(i, j int) bool {
+		if explain.Candidates[i].Included != explain.Candidates[j].Included {
+			return explain.Candidates[i].Included
+		}
+		return explain.Candidates[i].Priority > explain.Candidates[j].Priority
+	})
+	if len(explain.Tiers) == 0 {
+		return explain, nil
+	}
+
+	// 首次选择只在最高优先级内进行，灰度渠道按放量比例抽样，未抽中时在其余渠道中按权重随机
+	topChannels := lo.Filter(included, func(channel *Channel, _ int) bool {
+		return candidateMap[channel.Id].Tier == 0
+	})
+	remaining := 1.0
+	for _, channel := range topChannels {
+		if percent := candidateMap[channel.Id].CanaryPercent; percent > 0 {
+			candidateMap[channel.Id].Probability = min(remaining, percent/100)
+			remaining -= candidateMap[channel.Id].Probability
+		}
+	}
+	topChannels = lo.Filter(topChannels, func(channel *Channel, _ int) bool {
+		return candidateMap[channel.Id].CanaryPercent == 0
+	})
+	smoothingFactor := 10
+	weights := make([]float64, len(topChannels))
+	if common.MemoryCacheEnabled && operation_setting.GetLatencyRoutingSetting().Enabled {
+		weights = getLatencyAwareWeights(topChannels, modelName, smoothingFactor, now)
+	} else {
+		for i, channel := range topChannels {
+			weights[i] = float64(candidateMap[channel.Id].Weight + smoothingFactor)
+		}
+	}
+	totalWeight := lo.Sum(weights)
+	for i, channel := range topChannels {
+		if totalWeight > 0 {
+			candidateMap[channel.Id].Probability = remaining * weights[i] / totalWeight
+		}
+	}
+	return explain, nil
+}
@@ -0,0 +1,202 @@
+package model
+
+import (
+	"one-api/common"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// ShadowLog 一次被镜像的请求在主渠道与影子渠道上的结果，两侧都结束后写入
+type ShadowLog struct {
+	Id        int    `json:"id"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+	RequestId string `json:"request_id" gorm:"type:varchar(64);index"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	TokenId   int    `json:"token_id" gorm:"default:0"`
+	Group     string `json:"group"`
+	ModelName string `json:"model_name" gorm:"index;default:''"`
+	IsStream  bool   `json:"is_stream" gorm:"default:false"`
+
+	PrimaryChannelId        int    `json:"primary_channel_id" gorm:"index"`
+	PrimaryStatusCode       int    `json:"primary_status_code"`
+	PrimaryLatencyMs        int64  `json:"primary_latency_ms"`
+	PrimaryFirstByteMs      int64  `json:"primary_first_byte_ms"` // 首次向客户端写出内容的时间，没有写出时为 0
+	PrimaryPromptTokens     int    `json:"primary_prompt_tokens"`
+	PrimaryCompletionTokens int    `json:"primary_completion_tokens"`
+	PrimaryError            string `json:"primary_error"`
+	PrimaryOutput           string `json:"primary_output" gorm:"type:text"`
+
+	ShadowChannelId        int    `json:"shadow_channel_id" gorm:"index"`
+	ShadowStatusCode       int    `json:"shadow_status_code"`
+	ShadowLatencyMs        int64  `json:"shadow_latency_ms"`
+	ShadowFirstByteMs      int64  `json:"shadow_first_byte_ms"`
+	ShadowPromptTokens     int    `json:"shadow_prompt_tokens"`
+	ShadowCompletionTokens int    `json:"shadow_completion_tokens"`
+	ShadowError            string `json:"shadow_error"`
+	ShadowOutput           string `json:"shadow_output" gorm:"type:text"`
+	// 两侧都记录了输出内容时才有意义
+	OutputRecorded bool `json:"output_recorded" gorm:"default:false"`
+}
+
+func RecordShadowLog(log *ShadowLog) {
+	log.CreatedAt = common.GetTimestamp()
+	if err := LOG_DB.Create(log).Error; err != nil {
+		common.SysError("failed to record shadow log: " + err.Error())
+	}
+}
+
+func buildShadowLogQuery(startTimestamp int64, endTimestamp int64, shadowChannelId int, modelName string) *gorm.DB {
+	tx := LOG_DB.Model(&ShadowLog{})
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if shadowChannelId != 0 {
+		tx = tx.Where("shadow_channel_id = ?", shadowChannelId)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	return tx
+}
+
+func GetShadowLogs(startTimestamp int64, endTimestamp int64, shadowChannelId int, modelName string, startIdx int, num int) (logs []*ShadowLog, total int64, err error) {
+	tx := buildShadowLogQuery(startTimestamp, endTimestamp, shadowChannelId, modelName)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, total, err
+}
+
+// ShadowSideStat 主渠道或影子渠道一侧的汇总，延迟只统计成功的请求
+type ShadowSideStat struct {
+	Success          int     `json:"success"`
+	SuccessRate      float64 `json:"success_rate"`
+	AvgLatencyMs     int64   `json:"avg_latency_ms"`
+	P50LatencyMs     int64   `json:"p50_latency_ms"`
+	P95LatencyMs     int64   `json:"p95_latency_ms"`
+	AvgFirstByteMs   int64   `json:"avg_first_byte_ms"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+}
+
+// ShadowComparison 影子渠道在某个模型上与主渠道对同一批请求的对比
+type ShadowComparison struct {
+	ShadowChannelId int            `json:"shadow_channel_id"`
+	ModelName       string         `json:"model_name"`
+	Requests        int            `json:"requests"`
+	Primary         ShadowSideStat `json:"primary"`
+	Shadow          ShadowSideStat `json:"shadow"`
+	// 一侧成功而另一侧失败的请求数
+	OutcomeMismatch int `json:"outcome_mismatch"`
+	// 两侧都成功且记录了输出内容的请求中，输出完全一致的请求数
+	OutputCompared int `json:"output_compared"`
+	OutputMatched  int `json:"output_matched"`
+}
+
+type shadowSideSamples struct {
+	latencies  []int64
+	firstBytes []int64
+}
+
+func (samples *shadowSideSamples) add(stat *ShadowSideStat, success bool, latencyMs int64, firstByteMs int64, promptTokens int, completionTokens int) {
+	stat.PromptTokens += promptTokens
+	stat.CompletionTokens += completionTokens
+	if !success {
+		return
+	}
+	stat.Success++
+	samples.latencies = append(samples.latencies, latencyMs)
+	if firstByteMs > 0 {
+		samples.firstBytes = append(samples.firstBytes, firstByteMs)
+	}
+}
+
+func (samples *shadowSideSamples) summarize(stat *ShadowSideStat, requests int) {
+	if requests > 0 {
+		stat.SuccessRate = float64(stat.Success) / float64(requests)
+	}
+	if len(samples.latencies) > 0 {
+		sort.Slice(samples.latencies, func(i, j int) bool { return samples.latencies[i] < samples.latencies[j] })
+		stat.AvgLatencyMs = sumInt64(samples.latencies) / int64(len(samples.latencies))
+		stat.P50LatencyMs = samples.latencies[(len(samples.latencies)-1)*50/100]
+		stat.P95LatencyMs = samples.latencies[(len(samples.latencies)-1)*95/100]
+	}
+	if len(samples.firstBytes) > 0 {
+		stat.AvgFirstByteMs = sumInt64(samples.firstBytes) / int64(len(samples.firstBytes))
+	}
+}
+
+func sumInt64(values []int64) int64 {
+	var sum int64
+	for _, value := range values {
+		sum += value
+	}
+	return sum
+}
+
+func isShadowStatusSuccess(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
+
+// GetShadowComparisons 按影子渠道与模型汇总时间范围内的对比记录
+func GetShadowComparisons(startTimestamp int64, endTimestamp int64, shadowChannelId int, modelName string) ([]*ShadowComparison, error) {
+	var logs []*ShadowLog
+	err := buildShadowLogQuery(startTimestamp, endTimestamp, shadowChannelId, modelName).
+		Omit("primary_output", "shadow_output").Order("id asc").Find(&logs).Error
+	if err != nil {
+		return nil, err
+	}
+	// 输出内容只在两侧都记录时加载，用于比较是否一致
+	var outputs []*ShadowLog
+	err = buildShadowLogQuery(startTimestamp, endTimestamp, shadowChannelId, modelName).
+		Select("id", "primary_output", "shadow_output").Where("output_recorded = ?", true).Find(&outputs).Error
+	if err != nil {
+		return nil, err
+	}
+	outputMatched := make(map[int]bool, len(outputs))
+	for _, log := range outputs {
+		outputMatched[log.Id] = log.PrimaryOutput == log.ShadowOutput
+	}
+
+	type comparisonKey struct {
+		channelId int
+		modelName string
+	}
+	comparisons := make([]*ShadowComparison, 0)
+	comparisonMap := make(map[comparisonKey]*ShadowComparison)
+	samples := make(map[*ShadowComparison][2]*shadowSideSamples)
+	for _, log := range logs {
+		key := comparisonKey{log.ShadowChannelId, log.ModelName}
+		comparison, ok := comparisonMap[key]
+		if !ok {
+			comparison = &ShadowComparison{ShadowChannelId: log.ShadowChannelId, ModelName: log.ModelName}
+			comparisonMap[key] = comparison
+			comparisons = append(comparisons, comparison)
+			samples[comparison] = [2]*shadowSideSamples{{}, {}}
+		}
+		comparison.Requests++
+		primarySuccess := isShadowStatusSuccess(log.PrimaryStatusCode)
+		shadowSuccess := isShadowStatusSuccess(log.ShadowStatusCode)
+		samples[comparison][0].add(&comparison.Primary, primarySuccess, log.PrimaryLatencyMs, log.PrimaryFirstByteMs, log.PrimaryPromptTokens, log.PrimaryCompletionTokens)
+		samples[comparison][1].add(&comparison.Shadow, shadowSuccess, log.ShadowLatencyMs, log.ShadowFirstByteMs, log.ShadowPromptTokens, log.ShadowCompletionTokens)
+		if primarySuccess != shadowSuccess {
+			comparison.OutcomeMismatch++
+		}
+		if matched, ok := outputMatched[log.Id]; ok && primarySuccess && shadowSuccess {
+			comparison.OutputCompared++
+			if matched {
+				comparison.OutputMatched++
+			}
+		}
+	}
+	for _, comparison := range comparisons {
+		samples[comparison][0].summarize(&comparison.Primary, comparison.Requests)
+		samples[comparison][1].summarize(&comparison.Shadow, comparison.Requests)
+	}
+	return comparisons, nil
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+var errSimulationSourceReadOnly = errors.New("simulation source database is read-only")
+
+// OpenSimulationSourceDB 打开配置的数据库作为模拟的数据来源，不执行迁移，也不创建默认的用户与虚拟策略
+// 数据来源只允许读取，任何写入都会返回错误，保证模拟命令不会修改线上数据库
+func OpenSimulationSourceDB() error {
+	db, err := chooseDB("SQL_DSN", false)
+	if err != nil {
+		return err
+	}
+	readOnly := func(tx *gorm.DB) {
+		_ = tx.AddError(errSimulationSourceReadOnly)
+	}
+	callbacks := db.Callback()
+	if err = callbacks.Create().Before("gorm:create").Register("simulation:read_only", readOnly); err != nil {
+		return err
+	}
+	if err = callbacks.Update().Before("gorm:update").Register("simulation:read_only", readOnly); err != nil {
+		return err
+	}
+	if err = callbacks.Delete().Before("gorm:delete").Register("simulation:read_only", readOnly); err != nil {
+		return err
+	}
+	if err = callbacks.Raw().Before("gorm:raw").Register("simulation:read_only", readOnly); err != nil {
+		return err
+	}
+	DB = db
+	return nil
+}
+
+// InitSimulationDB 将当前数据库中的渠道与虚拟策略复制到 sqlitePath 指定的独立 SQLite 数据库，并切换 DB 与 LOG_DB
+// 模拟过程中产生的日志、额度与渠道状态变化只写入该数据库，不会影响线上数据；rewriteChannel 用于在复制前改写渠道
+func InitSimulationDB(sqlitePath string, rewriteChannel func(channel *Channel)) error {
+	var channels []*Channel
+	if err := DB.Find(&channels).Error; err != nil {
+		return err
+	}
+	var policies []*Policy
+	// 数据来源不会被迁移，旧版本的数据库中可能还没有虚拟策略表
+	if DB.Migrator().HasTable(&Policy{}) {
+		if err := DB.Find(&policies).Error; err != nil {
+			return err
+		}
+	}
+
+	db, err := gorm.Open(sqlite.Open(sqlitePath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	DB = db
+	LOG_DB = db
+	common.UsingSQLite = true
+	common.UsingMySQL = false
+	common.UsingPostgreSQL = false
+	common.LogSqlType = common.DatabaseTypeSQLite
+	initCol()
+
+	err = DB.AutoMigrate(&Channel{}, &Token{}, &User{}, &Option{}, &Ability{}, &Log{}, &Policy{}, &ChannelKey{}, &ShadowLog{})
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if rewriteChannel != nil {
+			rewriteChannel(channel)
+		}
+		if err := DB.Create(channel).Error; err != nil {
+			return fmt.Errorf("failed to copy channel %d: %w", channel.Id, err)
+		}
+		if err := channel.AddAbilities(); err != nil {
+			return fmt.Errorf("failed to copy abilities of channel %d: %w", channel.Id, err)
+		}
+		if err := channel.SyncChannelKeys(); err != nil {
+			return fmt.Errorf("failed to copy keys of channel %d: %w", channel.Id, err)
+		}
+	}
+	for _, policy := range policies {
+		if err := DB.Create(policy).Error; err != nil {
+			return fmt.Errorf("failed to copy policy %s: %w", policy.Name, err)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"one-api/common"
@@ -11,22 +12,26 @@ import (
 )
 
 type Token struct {
-	Id                 int            `json:"id"`
-	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status             int            `json:"status" gorm:"default:1"`
-	Name               string         `json:"name" gorm:"index" `
-	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
-	AccessedTime       int64          `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime        int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota        int            `json:"remain_quota" gorm:"default:0"`
-	UnlimitedQuota     bool           `json:"unlimited_quota" gorm:"default:false"`
-	ModelLimitsEnabled bool           `json:"model_limits_enabled" gorm:"default:false"`
-	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
-	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
-	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
-	Group              string         `json:"group" gorm:"default:''"`
-	DeletedAt          gorm.DeletedAt `gorm:"index"`
+	Id                  int            `json:"id"`
+	UserId              int            `json:"user_id" gorm:"index"`
+	Key                 string         `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Status              int            `json:"status" gorm:"default:1"`
+	Name                string         `json:"name" gorm:"index" `
+	CreatedTime         int64          `json:"created_time" gorm:"bigint"`
+	AccessedTime        int64          `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime         int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota         int            `json:"remain_quota" gorm:"default:0"`
+	UnlimitedQuota      bool           `json:"unlimited_quota" gorm:"default:false"`
+	ModelLimitsEnabled  bool           `json:"model_limits_enabled" gorm:"default:false"`
+	ModelLimits         string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
+	AllowIps            *string        `json:"allow_ips" gorm:"default:''"`
+	UsedQuota           int            `json:"used_quota" gorm:"default:0"` // used quota
+	Group               string         `json:"group" gorm:"default:''"`
+	GrayLogicOptOut     bool           `json:"gray_logic_opt_out" gorm:"default:false"` // 不参与高负载降级
+	MaxConcurrency      int            `json:"max_concurrency" gorm:"default:0"`        // 同时进行的请求数上限，0 表示不限制
+	ModelFallbacks      string         `json:"model_fallbacks" gorm:"type:text"`        // 模型 -> 回退模型链的 JSON，覆盖分组的回退配置
+	RouteConstraintKeys string         `json:"route_constraint_keys" gorm:"default:''"` // 允许客户端通过 X-Route-Constraints 约束的渠道标签，逗号分隔，为空表示不允许
+	DeletedAt           gorm.DeletedAt `gorm:"index"`
 }
 
 func (token *Token) Clean() {
@@ -184,7 +189,7 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "gray_logic_opt_out", "max_concurrency", "model_fallbacks", "route_constraint_keys").Updates(token).Error
 	return err
 }
 
@@ -238,6 +243,39 @@ func (token *Token) GetModelLimitsMap() map[string]bool {
 	return limitsMap
 }
 
+func (token *Token) ValidateModelFallbacks() error {
+	if token.ModelFallbacks == "" {
+		return nil
+	}
+	fallbacks := make(map[string][]string)
+	return json.Unmarshal([]byte(token.ModelFallbacks), &fallbacks)
+}
+
+// GetModelFallbacks 解析令牌的模型回退链配置，未配置时返回 nil
+func (token *Token) GetModelFallbacks() map[string][]string {
+	if token.ModelFallbacks == "" {
+		return nil
+	}
+	fallbacks := make(map[string][]string)
+	err := json.Unmarshal([]byte(token.ModelFallbacks), &fallbacks)
+	if err != nil {
+		common.SysError("failed to unmarshal token model fallbacks: " + err.Error())
+		return nil
+	}
+	return fallbacks
+}
+
+// GetRouteConstraintKeys 返回令牌允许约束的渠道标签
+func (token *Token) GetRouteConstraintKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range strings.Split(token.RouteConstraintKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
 func DisableModelLimits(tokenId int) error {
 	token, err := GetTokenById(tokenId)
 	if err != nil {
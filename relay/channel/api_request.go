@@ -9,12 +9,15 @@ import (
 	"io"
 	"net/http"
 	common2 "one-api/common"
+	constant2 "one-api/constant"
 	"one-api/dto"
 	"one-api/relay/common"
 	"one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
 	"one-api/setting/operation_setting"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -38,23 +41,21 @@ func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Hea
 }
 
 func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
-	// VERIFLOW_DEBUG: 增加并发计数器
-	currentLoad := common2.IncrementActiveRequests()
-	// 确保在函数结束时减少计数器
-	defer func() {
-		common2.DecrementActiveRequests()
-	}()
+	// 在途请求计数由 Distribute 维护，覆盖整个响应（包括流式响应）的生命周期
+	currentLoad := common2.GetActiveRequests()
 
 	// VERIFLOW_DEBUG: 检查是否需要激活灰色逻辑
 	grayLogicActivated := false
-	if info.RelayMode == constant.RelayModeChatCompletions && common2.ShouldActivateGrayLogic() {
+	if info.RelayMode == constant.RelayModeChatCompletions &&
+		!common2.GetContextKeyBool(c, constant2.ContextKeyTokenGrayLogicOptOut) &&
+		operation_setting.GetGrayLogicSetting().ShouldActivate(info.UsingGroup) {
 		// 读取并修改请求体
 		modifiedBody, err := applyGrayLogic(c, requestBody, info, currentLoad)
 		if err != nil {
 			common2.LogError(c, "VERIFLOW_DEBUG: Failed to apply gray logic: "+err.Error())
 		} else if modifiedBody != nil {
 			requestBody = modifiedBody
-			grayLogicActivated = true
+			grayLogicActivated = len(info.GrayLogicRewrites) > 0
 		}
 	}
 
@@ -86,7 +87,11 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	return resp, nil
 }
 
-// VERIFLOW_DEBUG: applyGrayLogic 在高并发情况下修改请求参数
+// GrayLogicHeader 降级生效时写入响应的头，值为被改写的参数，如 "max_tokens=4096->100; temperature=0.7->1.2"
+const GrayLogicHeader = "X-New-Api-Degraded"
+
+// VERIFLOW_DEBUG: applyGrayLogic 在高并发情况下按 GrayLogicSetting 改写请求参数
+// 被改写的参数记录在 info.GrayLogicRewrites 中，并通过响应头告知调用方
 func applyGrayLogic(c *gin.Context, requestBody io.Reader, info *common.RelayInfo, currentLoad int64) (io.Reader, error) {
 	if requestBody == nil {
 		return nil, nil
@@ -106,51 +111,70 @@ func applyGrayLogic(c *gin.Context, requestBody io.Reader, info *common.RelayInf
 	}
 
 	// 获取灰色逻辑配置
-	grayMaxTokens, grayTemperature, _ := common2.GetGrayLogicConstants()
-
-	// 记录原始参数（用于调试）
-	originalMaxTokens := chatRequest.MaxTokens
-	var originalTemperature float64
-	if chatRequest.Temperature != nil {
-		originalTemperature = *chatRequest.Temperature
-	}
-
-	// 应用灰色逻辑：修改参数
-	modified := false
-	grayMaxTokensUint := uint(grayMaxTokens)
-	if chatRequest.MaxTokens > grayMaxTokensUint {
-		chatRequest.MaxTokens = grayMaxTokensUint
-		modified = true
-	}
-	if chatRequest.MaxCompletionTokens > grayMaxTokensUint {
-		chatRequest.MaxCompletionTokens = grayMaxTokensUint
-		modified = true
-	}
-	
-	// Temperature 是 *float64 类型，需要创建指针
-	grayTemperatureFloat64 := float64(grayTemperature)
-	chatRequest.Temperature = &grayTemperatureFloat64
-	modified = true
-
-	if modified {
-		// 记录灰色逻辑激活
-		common2.LogInfo(c, fmt.Sprintf(
-			"VERIFLOW_DEBUG: Gray Logic activated. Current load: %d. Modifying request for model: %s. "+
-				"MaxTokens: %d->%d, Temperature: %f->%f",
-			currentLoad, chatRequest.Model, originalMaxTokens, chatRequest.MaxTokens, 
-			originalTemperature, grayTemperatureFloat64))
-
-		// 重新序列化修改后的请求
-		modifiedBody, err := json.Marshal(chatRequest)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal modified request: %w", err)
+	grayLogicSetting := operation_setting.GetGrayLogicSetting()
+
+	// 应用灰色逻辑：修改参数，并记录每个参数的原值与新值
+	rewrites := make(map[string]interface{})
+	if grayLogicSetting.MaxTokens > 0 {
+		grayMaxTokens := uint(grayLogicSetting.MaxTokens)
+		if chatRequest.MaxTokens > grayMaxTokens {
+			rewrites["max_tokens"] = map[string]interface{}{"from": chatRequest.MaxTokens, "to": grayMaxTokens}
+			chatRequest.MaxTokens = grayMaxTokens
+		}
+		if chatRequest.MaxCompletionTokens > grayMaxTokens {
+			rewrites["max_completion_tokens"] = map[string]interface{}{"from": chatRequest.MaxCompletionTokens, "to": grayMaxTokens}
+			chatRequest.MaxCompletionTokens = grayMaxTokens
+		}
+	}
+	if grayLogicSetting.TemperatureEnabled {
+		grayTemperature := grayLogicSetting.Temperature
+		if chatRequest.Temperature == nil || *chatRequest.Temperature != grayTemperature {
+			var originalTemperature interface{}
+			if chatRequest.Temperature != nil {
+				originalTemperature = *chatRequest.Temperature
+			}
+			rewrites["temperature"] = map[string]interface{}{"from": originalTemperature, "to": grayTemperature}
+			chatRequest.Temperature = &grayTemperature
 		}
+	}
+
+	if len(rewrites) == 0 {
+		// 如果没有修改，返回原始请求体
+		return bytes.NewReader(bodyBytes), nil
+	}
 
-		return bytes.NewReader(modifiedBody), nil
+	// 重新序列化修改后的请求
+	modifiedBody, err := json.Marshal(chatRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal modified request: %w", err)
 	}
 
-	// 如果没有修改，返回原始请求体
-	return bytes.NewReader(bodyBytes), nil
+	info.GrayLogicRewrites = rewrites
+	headerValue := formatGrayLogicRewrites(rewrites)
+	c.Writer.Header().Set(GrayLogicHeader, headerValue)
+	// 记录灰色逻辑激活
+	common2.LogInfo(c, fmt.Sprintf("VERIFLOW_DEBUG: Gray Logic activated. Current load: %d. Modifying request for model: %s. %s",
+		currentLoad, chatRequest.Model, headerValue))
+
+	return bytes.NewReader(modifiedBody), nil
+}
+
+func formatGrayLogicRewrites(rewrites map[string]interface{}) string {
+	keys := make([]string, 0, len(rewrites))
+	for k := range rewrites {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		rewrite := rewrites[k].(map[string]interface{})
+		from := "unset"
+		if rewrite["from"] != nil {
+			from = fmt.Sprintf("%v", rewrite["from"])
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s->%v", k, from, rewrite["to"]))
+	}
+	return strings.Join(parts, "; ")
 }
 
 func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
@@ -311,12 +335,18 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		client = service.GetHttpClient()
 	}
 
+	// 对冲请求在胜出前不能向客户端写入任何内容，因此不发送 ping，并在落败时取消上游请求
+	hedgeAttempt, isHedged := service.GetHedgeAttempt(c)
+	if isHedged {
+		req = req.WithContext(hedgeAttempt.Context)
+	}
+
 	var stopPinger context.CancelFunc
 	if info.IsStream {
 		helper.SetEventStreamHeaders(c)
 		// 处理流式请求的 ping 保活
 		generalSettings := operation_setting.GetGeneralSetting()
-		if generalSettings.PingIntervalEnabled {
+		if generalSettings.PingIntervalEnabled && !isHedged {
 			pingInterval := time.Duration(generalSettings.PingIntervalSeconds) * time.Second
 			stopPinger = startPingKeepAlive(c, pingInterval)
 			// 使用defer确保在任何情况下都能停止ping goroutine
@@ -331,6 +361,7 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	info.UpstreamStartTime = time.Now()
 	resp, err := client.Do(req)
 
 	if err != nil {
@@ -339,7 +370,6 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
-
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
 	return resp, nil
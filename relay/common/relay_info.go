@@ -62,6 +62,7 @@ type ResponsesUsageInfo struct {
 type RelayInfo struct {
 	ChannelType       int
 	ChannelId         int
+	ChannelKeyId      int // 多密钥渠道本次使用的密钥
 	TokenId           int
 	TokenKey          string
 	UserId            int
@@ -70,6 +71,7 @@ type RelayInfo struct {
 	TokenUnlimited    bool
 	StartTime         time.Time
 	FirstResponseTime time.Time
+	UpstreamStartTime time.Time // 本次尝试向上游发出请求的时间，重试与对冲时各自记录
 	isFirstResponse   bool
 	//SendLastReasoningResponse bool
 	ApiType           int
@@ -105,6 +107,11 @@ type RelayInfo struct {
 	RelayFormat          string
 	SendResponseCount    int
 	ChannelCreateTime    int64
+	GrayLogicRewrites    map[string]interface{} // 高负载降级改写的参数，key 为参数名
+	PolicyName           string                 // 请求的虚拟策略模型名称，非虚拟策略请求为空
+	PolicyStrategy       string
+	UsePolicyPrice       bool   // 是否按虚拟策略自身的固定价格计费
+	FallbackFrom         string // 发生模型回退时的主模型，此时按实际响应的模型计费
 	ThinkingContentInfo
 	*ClaudeConvertInfo
 	*RerankerInfo
@@ -233,6 +240,7 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		RequestURLPath:    c.Request.URL.String(),
 		ChannelType:       channelType,
 		ChannelId:         channelId,
+		ChannelKeyId:      common.GetContextKeyInt(c, constant.ContextKeyChannelKeyId),
 		TokenId:           tokenId,
 		TokenKey:          tokenKey,
 		UserId:            userId,
@@ -253,6 +261,9 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		ChannelCreateTime: c.GetInt64("channel_create_time"),
 		ParamOverride:     paramOverride,
 		RelayFormat:       RelayFormatOpenAI,
+		PolicyName:        common.GetContextKeyString(c, constant.ContextKeyPolicyName),
+		PolicyStrategy:    common.GetContextKeyString(c, constant.ContextKeyPolicyStrategy),
+		FallbackFrom:      common.GetContextKeyString(c, constant.ContextKeyFallbackFrom),
 		ThinkingContentInfo: ThinkingContentInfo{
 			IsFirstThinkingContent:  true,
 			SendLastThinkingContent: false,
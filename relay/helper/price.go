@@ -64,6 +64,13 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) GroupR
 
 func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, maxTokens int) (PriceData, error) {
 	modelPrice, usePrice := ratio_setting.GetModelPrice(info.OriginModelName, false)
+	if info.PolicyName != "" && info.FallbackFrom == "" {
+		// 虚拟策略配置了固定价格时，按策略价格计费；回退到其他模型后按该模型的价格计费
+		if policyPrice, ok := ratio_setting.GetPolicyPrice(info.PolicyName); ok {
+			modelPrice, usePrice = policyPrice, true
+			info.UsePolicyPrice = true
+		}
+	}
 
 	groupRatioInfo := HandleGroupRatio(c, info)
 
@@ -54,7 +54,9 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	)
 
 	generalSettings := operation_setting.GetGeneralSetting()
-	pingEnabled := generalSettings.PingIntervalEnabled
+	// 对冲请求以首次写出决定胜出，保活 ping 不能先于响应内容写出
+	_, isHedged := c.Get(string(constant.ContextKeyHedgeAttempt))
+	pingEnabled := generalSettings.PingIntervalEnabled && !isHedged
 	pingInterval := time.Duration(generalSettings.PingIntervalSeconds) * time.Second
 	if pingInterval <= 0 {
 		pingInterval = DefaultPingInterval
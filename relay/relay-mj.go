@@ -233,6 +233,7 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 			})
 			model.UpdateUserUsedQuotaAndRequestCount(userId, priceData.Quota)
 			model.UpdateChannelUsedQuota(channelId, priceData.Quota)
+			model.UpdateChannelKeyUsedQuota(relayInfo.ChannelKeyId, priceData.Quota)
 		}
 	}()
 	midjResponse := &mjResp.Response
@@ -539,6 +540,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 			})
 			model.UpdateUserUsedQuotaAndRequestCount(userId, priceData.Quota)
 			model.UpdateChannelUsedQuota(channelId, priceData.Quota)
+			model.UpdateChannelKeyUsedQuota(relayInfo.ChannelKeyId, priceData.Quota)
 		}
 	}()
 
@@ -284,6 +284,10 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
+	if service.IsShadowRequest(c) {
+		// 影子请求不向用户计费
+		return 0, 0, nil
+	}
 	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
@@ -341,6 +345,10 @@ func returnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, us
 
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
+	service.RecordRelayTTFT(relayInfo)
+	if service.RecordShadowUsage(ctx, relayInfo, usage) || service.RecordHedgeLoserUsage(ctx, relayInfo, usage, preConsumedQuota) {
+		return
+	}
 	if usage == nil {
 		usage = &dto.Usage{
 			PromptTokens:     relayInfo.PromptTokens,
@@ -489,6 +497,8 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		model.UpdateChannelKeyUsedQuota(relayInfo.ChannelKeyId, quota)
+		model.ConsumeChannelTokenBudget(relayInfo.ChannelId, relayInfo.OriginModelName, totalTokens)
 	}
 
 	quotaDelta := quota - preConsumedQuota
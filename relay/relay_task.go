@@ -152,6 +152,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 				})
 				model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 				model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+				model.UpdateChannelKeyUsedQuota(relayInfo.ChannelKeyId, quota)
 			}
 		}
 	}()
@@ -19,6 +19,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/uptime/status", controller.GetUptimeKumaStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
 		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+		apiRouter.GET("/status/inflight", middleware.AdminAuth(), controller.GetInflightRequests)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/about", controller.GetAbout)
 		//apiRouter.GET("/midjourney", controller.GetMidjourney)
@@ -97,7 +98,10 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/route_explain", controller.ExplainChannelRoute)
 			channelRoute.GET("/:id", controller.GetChannel)
+			channelRoute.GET("/:id/keys", controller.GetChannelKeys)
+			channelRoute.PUT("/:id/keys/status", controller.UpdateChannelKeyStatus)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
@@ -138,10 +142,23 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.DELETE("/invalid", controller.DeleteInvalidRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		policyRoute := apiRouter.Group("/policy")
+		policyRoute.Use(middleware.AdminAuth())
+		{
+			policyRoute.GET("/", controller.GetAllPolicies)
+			policyRoute.GET("/search", controller.SearchPolicies)
+			policyRoute.GET("/:id", controller.GetPolicy)
+			policyRoute.POST("/", controller.AddPolicy)
+			policyRoute.PUT("/", controller.UpdatePolicy)
+			policyRoute.DELETE("/:id", controller.DeletePolicy)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/affinity_stat", middleware.AdminAuth(), controller.GetPromptAffinityStats)
+		logRoute.GET("/shadow", middleware.AdminAuth(), controller.GetShadowLogs)
+		logRoute.GET("/shadow_stat", middleware.AdminAuth(), controller.GetShadowStats)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
@@ -9,6 +9,8 @@ import (
 	"one-api/model"
 	"one-api/setting/operation_setting"
 	"strings"
+
+	"github.com/gin-gonic/gin"
 )
 
 func formatNotifyType(channelId int, status int) string {
@@ -25,6 +27,41 @@ func DisableChannel(channelId int, channelName string, reason string) {
 	}
 }
 
+// DisableChannelKey 禁用多密钥渠道中的单个密钥，渠道的密钥全部被禁用时再禁用渠道
+func DisableChannelKey(channelId int, channelKeyId int, channelName string, reason string) {
+	success := model.UpdateChannelKeyStatus(channelKeyId, common.ChannelStatusAutoDisabled, reason)
+	if !success {
+		return
+	}
+	subject := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被禁用", channelName, channelId, channelKeyId)
+	content := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被禁用，原因：%s", channelName, channelId, channelKeyId, reason)
+	NotifyRootUser(fmt.Sprintf("%s_%d_key_%d", dto.NotifyTypeChannelUpdate, channelId, channelKeyId), subject, content)
+	if model.CountEnabledChannelKeys(channelId) == 0 {
+		DisableChannel(channelId, channelName, "所有密钥均已被禁用，最后一个密钥的禁用原因："+reason)
+	}
+}
+
+// EnableChannelKey 重新启用多密钥渠道中被自动禁用的单个密钥，渠道因密钥全部被禁用而被自动禁用时一并启用
+func EnableChannelKey(channelId int, channelKeyId int, channelName string) {
+	success := model.UpdateChannelKeyStatus(channelKeyId, common.ChannelStatusEnabled, "")
+	if !success {
+		return
+	}
+	subject := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被启用", channelName, channelId, channelKeyId)
+	content := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被启用", channelName, channelId, channelKeyId)
+	NotifyRootUser(fmt.Sprintf("%s_%d_key_%d", dto.NotifyTypeChannelUpdate, channelId, channelKeyId), subject, content)
+	EnableChannelOfKey(channelId)
+}
+
+// EnableChannelOfKey 密钥重新启用后，启用因密钥全部被禁用而被自动禁用的渠道，手动禁用的渠道保持不变
+func EnableChannelOfKey(channelId int) {
+	channel, err := model.GetChannelById(channelId, false)
+	if err != nil || channel.Status != common.ChannelStatusAutoDisabled {
+		return
+	}
+	EnableChannel(channel.Id, channel.Name)
+}
+
 func EnableChannel(channelId int, channelName string) {
 	success := model.UpdateChannelStatusById(channelId, common.ChannelStatusEnabled, "")
 	if success {
@@ -34,6 +71,47 @@ func EnableChannel(channelId int, channelName string) {
 	}
 }
 
+func recordChannelCanaryResult(c *gin.Context, channelId int, success bool) {
+	// 只统计选择渠道时处于灰度中的渠道，灰度状态以渠道的最新数据为准
+	setting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
+	if !ok || setting.Canary == nil || !setting.Canary.Enabled {
+		return
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		return
+	}
+	event := model.RecordChannelCanaryResult(channel, success)
+	if event == nil {
+		return
+	}
+	if event.Rollback {
+		RollbackCanaryChannel(event)
+		return
+	}
+	if event.Percent >= 100 {
+		subject := fmt.Sprintf("通道「%s」（#%d）灰度放量已完成", event.ChannelName, event.ChannelId)
+		content := fmt.Sprintf("通道「%s」（#%d）灰度放量已完成，最后一个阶段的请求数：%d，错误率：%.2f%%",
+			event.ChannelName, event.ChannelId, event.Requests, event.ErrorRate*100)
+		NotifyRootUser(fmt.Sprintf("%s_%d_canary_done", dto.NotifyTypeChannelUpdate, event.ChannelId), subject, content)
+		return
+	}
+	common.SysLog(fmt.Sprintf("channel #%d canary percent increased to %.2f%%", event.ChannelId, event.Percent))
+}
+
+// RollbackCanaryChannel 灰度渠道的错误率达到阈值时将其禁用并通知管理员
+// 使用手动禁用状态，避免被自动测试重新启用，管理员排查后手动启用即从当前比例继续放量
+func RollbackCanaryChannel(event *model.ChannelCanaryEvent) {
+	reason := fmt.Sprintf("灰度放量比例 %.2f%% 阶段内错误率 %.2f%%（%d/%d）达到阈值 %.2f%%，已回滚",
+		event.Percent, event.ErrorRate*100, event.Failures, event.Requests, event.Threshold*100)
+	success := model.UpdateChannelStatusById(event.ChannelId, common.ChannelStatusManuallyDisabled, reason)
+	if success {
+		subject := fmt.Sprintf("通道「%s」（#%d）灰度放量已回滚", event.ChannelName, event.ChannelId)
+		content := fmt.Sprintf("通道「%s」（#%d）已被禁用，原因：%s", event.ChannelName, event.ChannelId, reason)
+		NotifyRootUser(formatNotifyType(event.ChannelId, common.ChannelStatusManuallyDisabled), subject, content)
+	}
+}
+
 func ShouldDisableChannel(channelType int, err *dto.OpenAIErrorWithStatusCode) bool {
 	if !common.AutomaticDisableChannelEnabled {
 		return false
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"time"
+)
+
+// RecordRelayTTFT 请求完成后记录渠道的首字时间，用于延迟感知的渠道选择
+// 从本次尝试向上游发出请求开始计时，不包含重试、对冲等待等此前的耗时；
+// 流式请求以收到第一个数据块的时间为准，非流式请求或没有收到数据块时以完整响应的时间为准
+func RecordRelayTTFT(relayInfo *relaycommon.RelayInfo) {
+	if relayInfo.UpstreamStartTime.IsZero() {
+		return
+	}
+	ttft := time.Since(relayInfo.UpstreamStartTime)
+	if relayInfo.FirstResponseTime.After(relayInfo.UpstreamStartTime) {
+		ttft = relayInfo.FirstResponseTime.Sub(relayInfo.UpstreamStartTime)
+	}
+	model.RecordChannelTTFT(relayInfo.ChannelId, relayInfo.OriginModelName, ttft)
+}
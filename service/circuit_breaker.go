@@ -0,0 +1,33 @@
+package service
+
+import (
+	"net/http"
+	"one-api/dto"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecordChannelRelayResult 将一次转发结果计入渠道熔断器、延迟感知选择的错误率以及灰度渠道的阶段统计
+// 本地错误与一般的客户端错误（如 400）不代表渠道故障，不计入
+func RecordChannelRelayResult(c *gin.Context, channelId int, modelName string, err *dto.OpenAIErrorWithStatusCode) {
+	if err == nil {
+		model.RecordCircuitBreakerResult(channelId, modelName, true)
+		model.RecordChannelOutcome(channelId, modelName, true)
+		recordChannelCanaryResult(c, channelId, true)
+		return
+	}
+	if err.LocalError {
+		return
+	}
+	switch {
+	case err.StatusCode/100 == 5,
+		err.StatusCode == http.StatusTooManyRequests,
+		err.StatusCode == http.StatusRequestTimeout,
+		err.StatusCode == http.StatusUnauthorized,
+		err.StatusCode == http.StatusForbidden:
+		model.RecordCircuitBreakerResult(channelId, modelName, false)
+		model.RecordChannelOutcome(channelId, modelName, false)
+		recordChannelCanaryResult(c, channelId, false)
+	}
+}
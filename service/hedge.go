@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/operation_setting"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// HedgeRace 一次对冲请求中各个尝试之间的竞争，最先向客户端写出响应的尝试胜出，其余尝试立即被取消
+// 只收到响应头而没有写出内容的尝试不会胜出，响应头之后停滞或出错的上游不会取消健康的尝试
+type HedgeRace struct {
+	winner  atomic.Int32
+	lock    sync.Mutex
+	cancels map[int32]context.CancelFunc
+}
+
+// HedgeAttempt 对冲请求中的一次尝试，Context 用于取消发往上游的请求
+type HedgeAttempt struct {
+	Id      int32
+	Context context.Context
+	race    *HedgeRace
+}
+
+func NewHedgeRace() *HedgeRace {
+	return &HedgeRace{cancels: make(map[int32]context.CancelFunc)}
+}
+
+func (race *HedgeRace) NewAttempt(parent context.Context, id int32) *HedgeAttempt {
+	ctx, cancel := context.WithCancel(parent)
+	race.lock.Lock()
+	race.cancels[id] = cancel
+	race.lock.Unlock()
+	return &HedgeAttempt{Id: id, Context: ctx, race: race}
+}
+
+// Winner 返回胜出的尝试，尚未决出时返回 0
+func (race *HedgeRace) Winner() int32 {
+	return race.winner.Load()
+}
+
+// Claim 尝试成为胜出者并取消其他尝试，已由其他尝试胜出时返回 false
+func (attempt *HedgeAttempt) Claim() bool {
+	if attempt.race.winner.CompareAndSwap(0, attempt.Id) {
+		attempt.race.lock.Lock()
+		for id, cancel := range attempt.race.cancels {
+			if id != attempt.Id {
+				cancel()
+			}
+		}
+		attempt.race.lock.Unlock()
+		return true
+	}
+	return attempt.race.winner.Load() == attempt.Id
+}
+
+// Lost 其他尝试已经胜出
+func (attempt *HedgeAttempt) Lost() bool {
+	winner := attempt.race.winner.Load()
+	return winner != 0 && winner != attempt.Id
+}
+
+// Finish 尝试结束后释放其 Context
+func (attempt *HedgeAttempt) Finish() {
+	attempt.race.lock.Lock()
+	defer attempt.race.lock.Unlock()
+	if cancel, ok := attempt.race.cancels[attempt.Id]; ok {
+		cancel()
+	}
+}
+
+func GetHedgeAttempt(c *gin.Context) (*HedgeAttempt, bool) {
+	return common.GetContextKeyType[*HedgeAttempt](c, constant.ContextKeyHedgeAttempt)
+}
+
+// ShouldHedge 当前请求是否启用对冲，指定渠道的请求不对冲
+func ShouldHedge(c *gin.Context, group string) bool {
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	if group == "auto" {
+		group = c.GetString("auto_group")
+	}
+	return operation_setting.GetHedgeSetting().IsGroupEnabled(group)
+}
+
+// RecordHedgeLoserUsage 在计费前检查对冲尝试是否已经落败，返回 true 时调用方不应计费也不应记录消费日志
+// 落败的尝试退还预扣的额度，并记录上游返回的用量，由 RecordHedgeLoserLog 写入未计费的日志
+func RecordHedgeLoserUsage(c *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, preConsumedQuota int) bool {
+	attempt, ok := GetHedgeAttempt(c)
+	if !ok || !attempt.Lost() {
+		return false
+	}
+	common.SetContextKey(c, constant.ContextKeyHedgeUsage, usage)
+	if preConsumedQuota != 0 {
+		gopool.Go(func() {
+			relayInfoCopy := *relayInfo
+			if err := PostConsumeQuota(&relayInfoCopy, -preConsumedQuota, 0, false); err != nil {
+				common.SysError("error return pre-consumed quota of hedge loser: " + err.Error())
+			}
+		})
+	}
+	return true
+}
+
+// RecordHedgeLoserLog 记录未被采用的对冲请求，仅用于统计上游的额外消耗，不扣除用户额度
+// 上游已经返回用量时记录其提示与补全 token，否则只记录提示 token
+func RecordHedgeLoserLog(c *gin.Context, channelId int, modelName string) {
+	promptTokens, completionTokens := c.GetInt("prompt_tokens"), 0
+	if usage, ok := common.GetContextKeyType[*dto.Usage](c, constant.ContextKeyHedgeUsage); ok && usage != nil {
+		promptTokens, completionTokens = usage.PromptTokens, usage.CompletionTokens
+	}
+	other := make(map[string]interface{})
+	other["hedge_loser"] = true
+	adminInfo := make(map[string]interface{})
+	adminInfo["use_channel"] = c.GetStringSlice("use_channel")
+	other["admin_info"] = adminInfo
+	model.RecordConsumeLog(c, c.GetInt("id"), model.RecordConsumeLogParams{
+		ChannelId:        channelId,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		ModelName:        modelName,
+		TokenName:        c.GetString("token_name"),
+		Content:          "对冲请求未被采用，不计费",
+		TokenId:          c.GetInt("token_id"),
+		Group:            c.GetString("group"),
+		Other:            other,
+	})
+}
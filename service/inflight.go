@@ -0,0 +1,86 @@
+package service
+
+import (
+	"one-api/common"
+	"one-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InflightStats 本节点在途请求统计，Total 为集群范围的总数（启用 Redis 时）
+type InflightStats struct {
+	Total    int64            `json:"total"`
+	Node     int64            `json:"node"`
+	Models   map[string]int64 `json:"models"`
+	Channels map[int]int64    `json:"channels"`
+	Users    map[int]int64    `json:"users"`
+}
+
+func currentInflightKey(c *gin.Context) common.InflightKey {
+	return common.InflightKey{
+		Model:     c.GetString(string(constant.ContextKeyOriginalModel)),
+		ChannelId: common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+		UserId:    common.GetContextKeyInt(c, constant.ContextKeyUserId),
+		TokenId:   common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+	}
+}
+
+func getInflightKey(c *gin.Context) (common.InflightKey, bool) {
+	value, _ := common.GetContextKey(c, constant.ContextKeyInflight)
+	key, ok := value.(common.InflightKey)
+	return key, ok
+}
+
+// StartInflight 在分发完成后开始统计在途请求，需与 FinishInflight 成对调用
+// 用户、令牌或所选渠道的并发数已达上限时返回错误，此时不会计入
+func StartInflight(c *gin.Context) error {
+	key := currentInflightKey(c)
+	limits := common.InflightLimits{
+		User:    common.GetContextKeyInt(c, constant.ContextKeyUserMaxConcurrency),
+		Token:   common.GetContextKeyInt(c, constant.ContextKeyTokenMaxConcurrency),
+		Channel: common.GetContextKeyInt(c, constant.ContextKeyChannelMaxConcurrency),
+	}
+	if err := common.TryAddInflight(key, limits); err != nil {
+		return err
+	}
+	common.IncrementActiveRequests()
+	common.SetContextKey(c, constant.ContextKeyInflight, key)
+	return nil
+}
+
+// UpdateInflight 重试切换渠道或模型后，将在途请求改为计入新的渠道与模型，新渠道的并发数已达上限时返回错误
+func UpdateInflight(c *gin.Context) error {
+	key, ok := getInflightKey(c)
+	if !ok {
+		return nil
+	}
+	next := currentInflightKey(c)
+	if err := common.MoveInflight(key, next, common.GetContextKeyInt(c, constant.ContextKeyChannelMaxConcurrency)); err != nil {
+		return err
+	}
+	common.SetContextKey(c, constant.ContextKeyInflight, next)
+	return nil
+}
+
+// FinishInflight 响应完全写出后结束统计
+func FinishInflight(c *gin.Context) {
+	key, ok := getInflightKey(c)
+	if !ok {
+		return
+	}
+	common.RemoveInflight(key)
+	common.DecrementActiveRequests()
+	c.Set(string(constant.ContextKeyInflight), nil)
+}
+
+// GetInflightStats 获取在途请求统计的快照
+func GetInflightStats() InflightStats {
+	models, channels, users := common.GetInflightSnapshot()
+	return InflightStats{
+		Total:    common.GetActiveRequests(),
+		Node:     common.GetLocalActiveRequests(),
+		Models:   models,
+		Channels: channels,
+		Users:    users,
+	}
+}
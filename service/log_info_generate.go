@@ -26,6 +26,23 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if relayInfo.PolicyName != "" {
+		other["requested_model"] = relayInfo.PolicyName
+		other["resolved_model"] = relayInfo.OriginModelName
+		other["policy_strategy"] = relayInfo.PolicyStrategy
+		if relayInfo.UsePolicyPrice {
+			other["policy_price"] = true
+		}
+	}
+	if relayInfo.FallbackFrom != "" {
+		other["fallback_from"] = relayInfo.FallbackFrom
+	}
+	if IsPromptAffinityRouted(ctx, relayInfo.ChannelId) {
+		other["prompt_affinity"] = true
+	}
+	if len(relayInfo.GrayLogicRewrites) > 0 {
+		other["gray_logic"] = relayInfo.GrayLogicRewrites
+	}
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	other["admin_info"] = adminInfo
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// 比较按量与按次计费成员时，按 1K 输入 + 1K 输出 token 的参考请求折算价格
+const policyPriceReferenceTokens = 1000
+
+// ResolveVirtualPolicyModel 将虚拟策略模型解析为真实模型
+// 返回值：(策略, 真实模型名称, 是否为虚拟策略模型)
+func ResolveVirtualPolicyModel(c *gin.Context, modelName string, group string) (*model.Policy, string, bool) {
+	policy, ok := model.CacheGetPolicy(modelName)
+	if !ok {
+		return nil, modelName, false
+	}
+	var realModel string
+	if stickyModel, ok := GetStickyPolicyModel(c, policy); ok {
+		// 同一会话保持上一次成功使用的成员模型
+		realModel = stickyModel
+	} else if policy.Strategy == constant.PolicyStrategyCheapest {
+		realModel = resolvePolicyCheapest(c, policy, group)
+	} else {
+		realModel = policy.Resolve()
+	}
+	if realModel == "" {
+		return policy, modelName, false
+	}
+	return policy, realModel, true
+}
+
+// GetPolicyMemberOrder 返回虚拟策略成员被尝试的顺序，用于路由诊断
+// 首个成员按策略选出（weighted 策略取权重最高的成员），其余成员按成员顺序参与故障转移
+func GetPolicyMemberOrder(c *gin.Context, policy *model.Policy, group string) []string {
+	members := policy.GetMemberModels()
+	if len(members) == 0 {
+		return members
+	}
+	var first string
+	switch policy.Strategy {
+	case constant.PolicyStrategyCheapest:
+		first = resolvePolicyCheapest(c, policy, group)
+	case constant.PolicyStrategyWeighted:
+		first = lo.MaxBy(policy.GetMembers(), func(a model.PolicyMember, b model.PolicyMember) bool {
+			return a.Weight > b.Weight
+		}).Model
+	default:
+		first = policy.Resolve()
+	}
+	return append([]string{first}, lo.Without(members, first)...)
+}
+
+// resolvePolicyCheapest 在当前分组下有已启用渠道的成员中，选择实际价格最低的模型
+// 价格相同时按成员顺序选择；没有可用成员时返回第一个成员，由后续渠道选择给出错误
+func resolvePolicyCheapest(c *gin.Context, policy *model.Policy, group string) string {
+	members := policy.GetMemberModels()
+	if len(members) == 0 {
+		return ""
+	}
+	cheapestModel := ""
+	cheapestPrice := 0.0
+	for _, member := range members {
+		usingGroup, ok := model.GetModelEnabledGroup(group, member)
+		if !ok {
+			continue
+		}
+		price, ok := GetPolicyMemberEffectivePrice(c, member, usingGroup)
+		if !ok {
+			continue
+		}
+		if common.DebugEnabled {
+			common.LogInfo(c, fmt.Sprintf("virtual policy %s: member %s effective price %f in group %s", policy.Name, member, price, usingGroup))
+		}
+		if cheapestModel == "" || price < cheapestPrice {
+			cheapestModel = member
+			cheapestPrice = price
+		}
+	}
+	if cheapestModel == "" {
+		return members[0]
+	}
+	return cheapestModel
+}
+
+// GetPolicyMemberEffectivePrice 计算成员模型在指定分组下参考请求的额度消耗，未配置价格或倍率时返回 false
+func GetPolicyMemberEffectivePrice(c *gin.Context, modelName string, usingGroup string) (float64, bool) {
+	relayInfo := &relaycommon.RelayInfo{
+		UserGroup:  common.GetContextKeyString(c, constant.ContextKeyUserGroup),
+		UsingGroup: usingGroup,
+	}
+	groupRatio := helper.HandleGroupRatio(c, relayInfo).GroupRatio
+	if modelPrice, ok := ratio_setting.GetModelPrice(modelName, false); ok {
+		return modelPrice * common.QuotaPerUnit * groupRatio, true
+	}
+	modelRatio, ok := ratio_setting.GetModelRatio(modelName)
+	if !ok {
+		return 0, false
+	}
+	completionRatio := ratio_setting.GetCompletionRatio(modelName)
+	return policyPriceReferenceTokens * modelRatio * (1 + completionRatio) * groupRatio, true
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/setting"
+	"one-api/setting/operation_setting"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// promptAffinityRequest 用于提取提示词前缀的请求字段，兼容 OpenAI、Claude 与 Gemini 格式
+type promptAffinityRequest struct {
+	System            json.RawMessage   `json:"system"`
+	SystemInstruction json.RawMessage   `json:"systemInstruction"`
+	Messages          []json.RawMessage `json:"messages"`
+	Contents          []json.RawMessage `json:"contents"`
+}
+
+type promptAffinityMessage struct {
+	Role string `json:"role"`
+}
+
+// getPromptAffinityText 按配置的模式拼接参与哈希的提示词文本，无法识别提示词时返回空
+func getPromptAffinityText(request *promptAffinityRequest, mode string) string {
+	var system, prompt []string
+	// Claude 与 Gemini 的系统提示词位于消息之前
+	for _, raw := range []json.RawMessage{request.System, request.SystemInstruction} {
+		if len(raw) > 0 && string(raw) != "null" {
+			system = append(system, string(raw))
+			prompt = append(prompt, string(raw))
+		}
+	}
+	messages := request.Messages
+	if len(messages) == 0 {
+		messages = request.Contents
+	}
+	for _, raw := range messages {
+		var message promptAffinityMessage
+		_ = json.Unmarshal(raw, &message)
+		if message.Role == "system" || message.Role == "developer" {
+			system = append(system, string(raw))
+		}
+		prompt = append(prompt, string(raw))
+	}
+	if mode == operation_setting.PromptAffinityModeSystem && len(system) > 0 {
+		return strings.Join(system, "\n")
+	}
+	return strings.Join(prompt, "\n")
+}
+
+// getPromptAffinityKey 取提示词的前 PrefixTokens 个 token 计算哈希键
+func getPromptAffinityKey(c *gin.Context, modelName string) (uint64, bool) {
+	affinitySetting := operation_setting.GetPromptAffinitySetting()
+	var request promptAffinityRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return 0, false
+	}
+	text := getPromptAffinityText(&request, affinitySetting.Mode)
+	if text == "" {
+		return 0, false
+	}
+	// 先按字节粗略截断以避免对长提示词完整分词，每个 token 很少超过 8 个字节
+	if maxBytes := affinitySetting.PrefixTokens * 8; len(text) > maxBytes {
+		text = text[:maxBytes]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	hasher := sha256.New()
+	hasher.Write([]byte(modelName))
+	if defaultTokenEncoder != nil {
+		tokens, _, err := defaultTokenEncoder.Encode(text)
+		if err != nil {
+			return 0, false
+		}
+		if len(tokens) > affinitySetting.PrefixTokens {
+			tokens = tokens[:affinitySetting.PrefixTokens]
+		}
+		for _, token := range tokens {
+			hasher.Write(binary.BigEndian.AppendUint32(nil, uint32(token)))
+		}
+	} else {
+		hasher.Write([]byte(text))
+	}
+	return binary.BigEndian.Uint64(hasher.Sum(nil)[:8]), true
+}
+
+// GetPromptAffinityChannel 按提示词前缀的一致性哈希选择渠道，未启用、无法识别提示词或没有可用渠道时返回 false
+// auto 分组按 AutoGroups 的顺序查找模型所在的分组，该分组没有可用渠道时顺延到下一个分组
+func GetPromptAffinityChannel(c *gin.Context, group string, modelName string) (*model.Channel, string, bool) {
+	affinitySetting := operation_setting.GetPromptAffinitySetting()
+	if !affinitySetting.Enabled || !common.MemoryCacheEnabled {
+		return nil, group, false
+	}
+	groups := []string{group}
+	if group == "auto" {
+		groups = setting.AutoGroups
+	}
+	var key uint64
+	keyLoaded := false
+	for _, g := range groups {
+		if _, ok := model.GetModelEnabledGroup(g, modelName); !ok {
+			continue
+		}
+		if !affinitySetting.IsGroupEnabled(g) {
+			return nil, group, false
+		}
+		if !keyLoaded {
+			var ok bool
+			if key, ok = getPromptAffinityKey(c, modelName); !ok {
+				return nil, group, false
+			}
+			keyLoaded = true
+		}
+		channel, err := model.GetAffinityChannel(g, modelName, key, affinitySetting.VirtualNodes, model.GetRouteConstraints(c))
+		if err != nil {
+			continue
+		}
+		if group == "auto" {
+			c.Set("auto_group", g)
+		}
+		common.SetContextKey(c, constant.ContextKeyPromptAffinityChannelId, channel.Id)
+		return channel, g, true
+	}
+	return nil, group, false
+}
+
+// IsPromptAffinityRouted 请求最终使用的渠道是否由提示词亲和路由选出，重试切换到其他渠道后不再计入
+func IsPromptAffinityRouted(c *gin.Context, channelId int) bool {
+	return channelId != 0 && common.GetContextKeyInt(c, constant.ContextKeyPromptAffinityChannelId) == channelId
+}
@@ -201,6 +201,8 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		model.UpdateChannelKeyUsedQuota(relayInfo.ChannelKeyId, quota)
+		model.ConsumeChannelTokenBudget(relayInfo.ChannelId, relayInfo.OriginModelName, totalTokens)
 	}
 
 	logModel := modelName
@@ -229,6 +231,10 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 
+	RecordRelayTTFT(relayInfo)
+	if RecordShadowUsage(ctx, relayInfo, usage) || RecordHedgeLoserUsage(ctx, relayInfo, usage, preConsumedQuota) {
+		return
+	}
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
@@ -287,6 +293,8 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		model.UpdateChannelKeyUsedQuota(relayInfo.ChannelKeyId, quota)
+		model.ConsumeChannelTokenBudget(relayInfo.ChannelId, relayInfo.OriginModelName, totalTokens)
 	}
 
 	quotaDelta := quota - preConsumedQuota
@@ -341,6 +349,9 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData helper.PriceData
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 
+	if RecordShadowUsage(ctx, relayInfo, usage) || RecordHedgeLoserUsage(ctx, relayInfo, usage, preConsumedQuota) {
+		return
+	}
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
 	textOutTokens := usage.CompletionTokenDetails.TextTokens
@@ -395,6 +406,8 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		model.UpdateChannelKeyUsedQuota(relayInfo.ChannelKeyId, quota)
+		model.ConsumeChannelTokenBudget(relayInfo.ChannelId, relayInfo.OriginModelName, totalTokens)
 	}
 
 	quotaDelta := quota - preConsumedQuota
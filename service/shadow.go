@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/operation_setting"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// ShadowMirror 一次被镜像的请求，主渠道与影子渠道各占一侧，两侧都结束后写入对比记录
+type ShadowMirror struct {
+	Rule    operation_setting.ShadowRule
+	Primary *ShadowSide
+	Shadow  *ShadowSide
+
+	lock    sync.Mutex
+	pending int
+	log     *model.ShadowLog
+}
+
+// ShadowSide 镜像请求的一侧，记录写出的内容与用量，影子一侧的请求不计费
+type ShadowSide struct {
+	IsShadow bool
+	mirror   *ShadowMirror
+
+	start         time.Time
+	firstByte     time.Time
+	usage         *dto.Usage
+	output        bytes.Buffer
+	recordContent bool
+	maxLength     int
+}
+
+// NewShadowMirror 按影子流量规则抽样当前请求，未命中时返回 nil
+func NewShadowMirror(c *gin.Context, group string, modelName string) *ShadowMirror {
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return nil
+	}
+	if group == "auto" {
+		group = c.GetString("auto_group")
+	}
+	shadowSetting := operation_setting.GetShadowSetting()
+	rule, ok := shadowSetting.SampleShadowRule(group, modelName)
+	if !ok {
+		return nil
+	}
+	mirror := &ShadowMirror{
+		Rule:    rule,
+		pending: 2,
+		log: &model.ShadowLog{
+			RequestId:       c.GetString(common.RequestIdKey),
+			UserId:          c.GetInt("id"),
+			TokenId:         c.GetInt("token_id"),
+			Group:           group,
+			ModelName:       modelName,
+			ShadowChannelId: rule.ChannelId,
+			OutputRecorded:  rule.RecordContent,
+		},
+	}
+	now := time.Now()
+	mirror.Primary = &ShadowSide{mirror: mirror, start: now, recordContent: rule.RecordContent, maxLength: shadowSetting.MaxContentLength}
+	mirror.Shadow = &ShadowSide{IsShadow: true, mirror: mirror, start: now, recordContent: rule.RecordContent, maxLength: shadowSetting.MaxContentLength}
+	return mirror
+}
+
+func GetShadowSide(c *gin.Context) (*ShadowSide, bool) {
+	return common.GetContextKeyType[*ShadowSide](c, constant.ContextKeyShadowSide)
+}
+
+// IsShadowRequest 当前请求是否为发往影子渠道的镜像请求
+func IsShadowRequest(c *gin.Context) bool {
+	side, ok := GetShadowSide(c)
+	return ok && side.IsShadow
+}
+
+// RecordShadowUsage 在计费前记录被镜像请求的用量，返回 true 时为影子请求，调用方不应计费也不应记录消费日志
+func RecordShadowUsage(c *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) bool {
+	side, ok := GetShadowSide(c)
+	if !ok {
+		return false
+	}
+	side.mirror.lock.Lock()
+	side.usage = usage
+	if !side.IsShadow {
+		side.mirror.log.IsStream = relayInfo.IsStream
+	}
+	side.mirror.lock.Unlock()
+	return side.IsShadow
+}
+
+// Capture 记录写出的响应内容
+func (side *ShadowSide) Capture(data []byte) {
+	side.mirror.lock.Lock()
+	defer side.mirror.lock.Unlock()
+	if side.firstByte.IsZero() && len(data) > 0 {
+		side.firstByte = time.Now()
+	}
+	// 响应原文可能包含 SSE 的分隔与元数据，预留足够的空间以便提取出 maxLength 个字符的文本
+	if side.recordContent && side.output.Len() < side.maxLength*16 {
+		side.output.Write(data)
+	}
+}
+
+// Finish 记录一侧的结果，两侧都结束后写入对比记录
+func (side *ShadowSide) Finish(channelId int, statusCode int, errMessage string) {
+	mirror := side.mirror
+	mirror.lock.Lock()
+	defer mirror.lock.Unlock()
+	latency := time.Since(side.start).Milliseconds()
+	var firstByte int64
+	if !side.firstByte.IsZero() {
+		firstByte = side.firstByte.Sub(side.start).Milliseconds()
+	}
+	var promptTokens, completionTokens int
+	if side.usage != nil {
+		promptTokens, completionTokens = side.usage.PromptTokens, side.usage.CompletionTokens
+	}
+	var output string
+	if side.recordContent {
+		output = extractShadowOutput(side.output.Bytes(), side.maxLength)
+	}
+	log := mirror.log
+	if side.IsShadow {
+		log.ShadowStatusCode, log.ShadowLatencyMs, log.ShadowFirstByteMs = statusCode, latency, firstByte
+		log.ShadowPromptTokens, log.ShadowCompletionTokens = promptTokens, completionTokens
+		log.ShadowError, log.ShadowOutput = errMessage, output
+	} else {
+		log.PrimaryChannelId, log.PrimaryStatusCode, log.PrimaryLatencyMs, log.PrimaryFirstByteMs = channelId, statusCode, latency, firstByte
+		log.PrimaryPromptTokens, log.PrimaryCompletionTokens = promptTokens, completionTokens
+		log.PrimaryError, log.PrimaryOutput = errMessage, output
+	}
+	mirror.pending--
+	if mirror.pending == 0 {
+		gopool.Go(func() {
+			model.RecordShadowLog(log)
+		})
+	}
+}
+
+type shadowOutputChunk struct {
+	Choices []struct {
+		Message struct {
+			Content any `json:"content"`
+		} `json:"message"`
+		Delta struct {
+			Content any `json:"content"`
+		} `json:"delta"`
+		Text string `json:"text"`
+	} `json:"choices"`
+	// Claude 格式
+	Content []struct {
+		Text string `json:"text"`
+	} `json:"content"`
+	Delta struct {
+		Text string `json:"text"`
+	} `json:"delta"`
+}
+
+func (chunk *shadowOutputChunk) text() string {
+	var builder strings.Builder
+	for _, choice := range chunk.Choices {
+		if content, ok := choice.Message.Content.(string); ok {
+			builder.WriteString(content)
+		}
+		if content, ok := choice.Delta.Content.(string); ok {
+			builder.WriteString(content)
+		}
+		builder.WriteString(choice.Text)
+	}
+	for _, content := range chunk.Content {
+		builder.WriteString(content.Text)
+	}
+	builder.WriteString(chunk.Delta.Text)
+	return builder.String()
+}
+
+// extractShadowOutput 从 OpenAI 或 Claude 格式的响应（包括流式响应）中提取输出文本，无法解析时返回响应原文
+func extractShadowOutput(raw []byte, maxLength int) string {
+	var builder strings.Builder
+	parsed := false
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		var chunk shadowOutputChunk
+		if err := json.Unmarshal(raw, &chunk); err == nil {
+			builder.WriteString(chunk.text())
+			parsed = true
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		scanner.Buffer(make([]byte, 64*1024), len(raw)+1)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			data, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				continue
+			}
+			var chunk shadowOutputChunk
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err == nil {
+				builder.WriteString(chunk.text())
+				parsed = true
+			}
+		}
+	}
+	output := builder.String()
+	if !parsed {
+		output = string(raw)
+	}
+	if runes := []rune(output); len(runes) > maxLength {
+		output = string(runes[:maxLength])
+	}
+	return output
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StickyRoute 会话上一次成功请求使用的模型与渠道
+type StickyRoute struct {
+	Model     string `json:"model"`
+	ChannelId int    `json:"channel_id"`
+	Group     string `json:"group"`
+}
+
+type stickyRouteEntry struct {
+	route    StickyRoute
+	expireAt time.Time
+}
+
+// 未启用 Redis 时使用的内存存储
+var stickyRoutes = make(map[string]stickyRouteEntry)
+var stickyRoutesLock sync.Mutex
+var stickyRoutesLastCleanup time.Time
+
+// stickyMessagesRequest 用于识别会话的请求字段，兼容 OpenAI、Claude 与 Gemini 格式
+type stickyMessagesRequest struct {
+	System   json.RawMessage   `json:"system"`
+	Messages []json.RawMessage `json:"messages"`
+	Contents []json.RawMessage `json:"contents"`
+}
+
+func getStickyRedisKey(key string) string {
+	return "sticky_route:" + key
+}
+
+// getStickyKey 由会话请求头或令牌与前几条消息计算会话标识，无法识别会话时返回空
+func getStickyKey(c *gin.Context, requestModel string) string {
+	stickySetting := operation_setting.GetStickyRoutingSetting()
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	var source string
+	if sessionId := c.Request.Header.Get(stickySetting.SessionHeader); stickySetting.SessionHeader != "" && sessionId != "" {
+		source = fmt.Sprintf("session|%d|%s|%s", tokenId, requestModel, sessionId)
+	} else if stickySetting.HashMessages > 0 {
+		var request stickyMessagesRequest
+		if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+			return ""
+		}
+		messages := request.Messages
+		if len(messages) == 0 {
+			messages = request.Contents
+		}
+		if len(messages) == 0 {
+			return ""
+		}
+		if len(messages) > stickySetting.HashMessages {
+			messages = messages[:stickySetting.HashMessages]
+		}
+		messagesBytes, _ := json.Marshal(messages)
+		source = fmt.Sprintf("messages|%d|%s|%s|%s", tokenId, requestModel, request.System, messagesBytes)
+	} else {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(hash[:])
+}
+
+// LoadStickyRoute 识别会话并读取其粘性路由，结果保存在上下文中供模型解析与渠道选择使用
+func LoadStickyRoute(c *gin.Context, requestModel string) {
+	if !operation_setting.GetStickyRoutingSetting().Enabled {
+		return
+	}
+	key := getStickyKey(c, requestModel)
+	if key == "" {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyStickyKey, key)
+	route, ok := getStickyRoute(key)
+	if !ok {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyStickyRoute, route)
+}
+
+func getStickyRoute(key string) (*StickyRoute, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(getStickyRedisKey(key))
+		if err != nil {
+			return nil, false
+		}
+		var route StickyRoute
+		if err := json.Unmarshal([]byte(value), &route); err != nil {
+			return nil, false
+		}
+		return &route, true
+	}
+	stickyRoutesLock.Lock()
+	defer stickyRoutesLock.Unlock()
+	entry, ok := stickyRoutes[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expireAt) {
+		delete(stickyRoutes, key)
+		return nil, false
+	}
+	route := entry.route
+	return &route, true
+}
+
+func getContextStickyRoute(c *gin.Context) (*StickyRoute, bool) {
+	value, _ := common.GetContextKey(c, constant.ContextKeyStickyRoute)
+	route, ok := value.(*StickyRoute)
+	return route, ok && route != nil
+}
+
+// GetStickyPolicyModel 会话已粘滞到虚拟策略的某个成员模型时返回该模型
+func GetStickyPolicyModel(c *gin.Context, policy *model.Policy) (string, bool) {
+	route, ok := getContextStickyRoute(c)
+	if !ok {
+		return "", false
+	}
+	for _, member := range policy.GetMemberModels() {
+		if member == route.Model {
+			return member, true
+		}
+	}
+	return "", false
+}
+
+// GetStickyChannel 会话已粘滞到某个渠道且该渠道仍然健康时返回该渠道及其分组
+// 粘滞到灰度渠道的会话每次请求按放量比例抽样，未抽中时重新选择渠道
+func GetStickyChannel(c *gin.Context, group string, modelName string) (*model.Channel, string, bool) {
+	route, ok := getContextStickyRoute(c)
+	if !ok || route.Model != modelName {
+		return nil, group, false
+	}
+	selectGroup := group
+	if group == "auto" {
+		selectGroup = route.Group
+	} else if route.Group != group {
+		return nil, group, false
+	}
+	if !model.IsChannelEnabledForModel(selectGroup, modelName, route.ChannelId) {
+		return nil, group, false
+	}
+	channel, err := model.CacheGetChannel(route.ChannelId)
+	if err != nil || channel.Status != common.ChannelStatusEnabled || channel.IsSaturated() || !channel.IsScheduledAvailable(time.Now()) ||
+		!model.GetRouteConstraints(c).Match(channel) || !channel.SampleCanary() ||
+		!model.IsCircuitBreakerAllowed(channel.Id, modelName) || !model.TryAcquireChannelRateLimit(channel, modelName) {
+		return nil, group, false
+	}
+	if group == "auto" {
+		c.Set("auto_group", selectGroup)
+	}
+	return channel, selectGroup, true
+}
+
+// SaveStickyRoute 请求成功后记录会话最终使用的模型与渠道，并刷新 TTL
+func SaveStickyRoute(c *gin.Context) {
+	key := common.GetContextKeyString(c, constant.ContextKeyStickyKey)
+	if key == "" || c.Writer.Status() >= 400 {
+		return
+	}
+	channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId)
+	if channelId == 0 {
+		return
+	}
+	group := c.GetString("auto_group")
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	}
+	route := StickyRoute{
+		Model:     common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		ChannelId: channelId,
+		Group:     group,
+	}
+	ttl := time.Duration(operation_setting.GetStickyRoutingSetting().TTLSeconds) * time.Second
+	if common.RedisEnabled {
+		routeBytes, _ := json.Marshal(route)
+		if err := common.RedisSet(getStickyRedisKey(key), string(routeBytes), ttl); err != nil {
+			common.SysError("failed to save sticky route: " + err.Error())
+		}
+		return
+	}
+	stickyRoutesLock.Lock()
+	defer stickyRoutesLock.Unlock()
+	now := time.Now()
+	stickyRoutes[key] = stickyRouteEntry{route: route, expireAt: now.Add(ttl)}
+	// 每分钟顺便清理一次过期的会话，避免内存无限增长
+	if now.Sub(stickyRoutesLastCleanup) > time.Minute {
+		stickyRoutesLastCleanup = now
+		for k, entry := range stickyRoutes {
+			if now.After(entry.expireAt) {
+				delete(stickyRoutes, k)
+			}
+		}
+	}
+}
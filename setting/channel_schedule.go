@@ -0,0 +1,149 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"sync"
+	"time"
+)
+
+// ChannelScheduleRule 解析后的渠道或标签时间计划
+type ChannelScheduleRule struct {
+	location    *time.Location
+	available   []*common.CronSchedule
+	unavailable []*common.CronSchedule
+	overrides   []channelScheduleOverrideRule
+}
+
+type channelScheduleOverrideRule struct {
+	cron     *common.CronSchedule
+	priority *int64
+	weight   *uint
+}
+
+func parseCronList(exprs []string) ([]*common.CronSchedule, error) {
+	schedules := make([]*common.CronSchedule, 0, len(exprs))
+	for _, expr := range exprs {
+		schedule, err := common.ParseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// CompileChannelSchedule 解析时间计划中的时区与 cron 表达式
+func CompileChannelSchedule(schedule *dto.ChannelSchedule) (*ChannelScheduleRule, error) {
+	rule := &ChannelScheduleRule{location: time.Local}
+	if schedule.Timezone != "" {
+		location, err := time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %s", schedule.Timezone, err.Error())
+		}
+		rule.location = location
+	}
+	var err error
+	if rule.available, err = parseCronList(schedule.Available); err != nil {
+		return nil, err
+	}
+	if rule.unavailable, err = parseCronList(schedule.Unavailable); err != nil {
+		return nil, err
+	}
+	for _, override := range schedule.Overrides {
+		cron, err := common.ParseCron(override.Cron)
+		if err != nil {
+			return nil, err
+		}
+		rule.overrides = append(rule.overrides, channelScheduleOverrideRule{cron, override.Priority, override.Weight})
+	}
+	return rule, nil
+}
+
+// IsAvailable 当前时间是否处于可用窗口内且不在不可用窗口内
+func (rule *ChannelScheduleRule) IsAvailable(now time.Time) bool {
+	now = now.In(rule.location)
+	for _, schedule := range rule.unavailable {
+		if schedule.Match(now) {
+			return false
+		}
+	}
+	if len(rule.available) == 0 {
+		return true
+	}
+	for _, schedule := range rule.available {
+		if schedule.Match(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOverride 返回当前时间第一个匹配的优先级与权重覆盖，未匹配的项为 nil
+func (rule *ChannelScheduleRule) GetOverride(now time.Time) (priority *int64, weight *uint) {
+	now = now.In(rule.location)
+	for _, override := range rule.overrides {
+		if override.cron.Match(now) {
+			return override.priority, override.weight
+		}
+	}
+	return nil, nil
+}
+
+// tagSchedules 标签 -> 时间计划，对该标签下的所有渠道生效
+// 例如 {"azure": {"timezone": "Asia/Shanghai", "unavailable": ["* 2-3 * * 0"]}}
+var tagSchedules = map[string]dto.ChannelSchedule{}
+var tagScheduleRules = map[string]*ChannelScheduleRule{}
+var tagSchedulesMutex sync.RWMutex
+
+func TagSchedules2JSONString() string {
+	tagSchedulesMutex.RLock()
+	defer tagSchedulesMutex.RUnlock()
+	jsonBytes, err := json.Marshal(tagSchedules)
+	if err != nil {
+		common.SysError("error marshalling tag schedules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func compileTagSchedules(jsonStr string) (map[string]dto.ChannelSchedule, map[string]*ChannelScheduleRule, error) {
+	schedules := make(map[string]dto.ChannelSchedule)
+	if err := json.Unmarshal([]byte(jsonStr), &schedules); err != nil {
+		return nil, nil, err
+	}
+	rules := make(map[string]*ChannelScheduleRule, len(schedules))
+	for tag, schedule := range schedules {
+		rule, err := CompileChannelSchedule(&schedule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("标签 %s 的时间计划无效：%s", tag, err.Error())
+		}
+		rules[tag] = rule
+	}
+	return schedules, rules, nil
+}
+
+func CheckTagSchedules(jsonStr string) error {
+	_, _, err := compileTagSchedules(jsonStr)
+	return err
+}
+
+func UpdateTagSchedulesByJSONString(jsonStr string) error {
+	schedules, rules, err := compileTagSchedules(jsonStr)
+	if err != nil {
+		return err
+	}
+	tagSchedulesMutex.Lock()
+	defer tagSchedulesMutex.Unlock()
+	tagSchedules = schedules
+	tagScheduleRules = rules
+	return nil
+}
+
+// GetTagScheduleRule 返回标签的时间计划，未配置时返回 nil
+func GetTagScheduleRule(tag string) *ChannelScheduleRule {
+	tagSchedulesMutex.RLock()
+	defer tagSchedulesMutex.RUnlock()
+	return tagScheduleRules[tag]
+}
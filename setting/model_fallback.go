@@ -0,0 +1,66 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"sync"
+)
+
+// modelFallbackChains 分组 -> 模型 -> 回退模型链，主模型的所有渠道都失败后按顺序尝试链上的模型
+// 例如 {"default": {"gpt-4o": ["gpt-4.1", "claude-sonnet-4-20250514"]}}
+var modelFallbackChains = map[string]map[string][]string{}
+var modelFallbackChainsMutex sync.RWMutex
+
+func ModelFallbackChains2JSONString() string {
+	modelFallbackChainsMutex.RLock()
+	defer modelFallbackChainsMutex.RUnlock()
+	jsonBytes, err := json.Marshal(modelFallbackChains)
+	if err != nil {
+		common.SysError("error marshalling model fallback chains: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func parseModelFallbackChains(jsonStr string) (map[string]map[string][]string, error) {
+	chains := make(map[string]map[string][]string)
+	if err := json.Unmarshal([]byte(jsonStr), &chains); err != nil {
+		return nil, err
+	}
+	for group, models := range chains {
+		for model, chain := range models {
+			for _, fallbackModel := range chain {
+				if fallbackModel == "" {
+					return nil, fmt.Errorf("分组 %s 下模型 %s 的回退链包含空的模型名称", group, model)
+				}
+				if fallbackModel == model {
+					return nil, fmt.Errorf("分组 %s 下模型 %s 的回退链不能包含其自身", group, model)
+				}
+			}
+		}
+	}
+	return chains, nil
+}
+
+func CheckModelFallbackChains(jsonStr string) error {
+	_, err := parseModelFallbackChains(jsonStr)
+	return err
+}
+
+func UpdateModelFallbackChainsByJSONString(jsonStr string) error {
+	chains, err := parseModelFallbackChains(jsonStr)
+	if err != nil {
+		return err
+	}
+	modelFallbackChainsMutex.Lock()
+	defer modelFallbackChainsMutex.Unlock()
+	modelFallbackChains = chains
+	return nil
+}
+
+// GetModelFallbackChain 返回分组下模型的回退链，未配置时返回 nil
+func GetModelFallbackChain(group string, model string) []string {
+	modelFallbackChainsMutex.RLock()
+	defer modelFallbackChainsMutex.RUnlock()
+	return modelFallbackChains[group][model]
+}
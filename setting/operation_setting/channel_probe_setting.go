@@ -0,0 +1,37 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ChannelProbeSetting 自动禁用渠道的重新探测：每个渠道按自己的计划用测试模型发送探测请求，失败后间隔按倍数增长
+// 连续成功 SuccessThreshold 次后重新启用渠道；多密钥渠道逐个探测被自动禁用的密钥，密钥通过后重新启用密钥与渠道
+// 开启后定时的全部渠道测试不再测试自动禁用的渠道与密钥
+type ChannelProbeSetting struct {
+	Enabled                bool    `json:"enabled"`
+	InitialIntervalSeconds int     `json:"initial_interval_seconds"` // 禁用后首次探测以及探测成功后下一次探测的间隔
+	MaxIntervalSeconds     int     `json:"max_interval_seconds"`
+	Multiplier             float64 `json:"multiplier"` // 每次探测失败后间隔乘以该倍数
+	SuccessThreshold       int     `json:"success_threshold"`
+	HistorySize            int     `json:"history_size"` // 渠道上保留的探测记录条数
+	// 探测请求，OpenAI 格式的 JSON，model 字段会被替换为渠道的测试模型；为空时使用与渠道测试相同的请求
+	ProbeRequest string `json:"probe_request"`
+}
+
+// 默认配置
+var channelProbeSetting = ChannelProbeSetting{
+	Enabled:                false,
+	InitialIntervalSeconds: 60,
+	MaxIntervalSeconds:     6 * 3600,
+	Multiplier:             2,
+	SuccessThreshold:       3,
+	HistorySize:            20,
+	ProbeRequest:           "",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_probe_setting", &channelProbeSetting)
+}
+
+func GetChannelProbeSetting() *ChannelProbeSetting {
+	return &channelProbeSetting
+}
@@ -0,0 +1,36 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// CircuitBreakerSetting 渠道熔断配置，按渠道以及渠道+模型分别统计
+type CircuitBreakerSetting struct {
+	Enabled             bool    `json:"enabled"`
+	ConsecutiveFailures int     `json:"consecutive_failures"` // 连续失败次数达到该值时熔断，0 表示不按连续失败熔断
+	ErrorRateThreshold  float64 `json:"error_rate_threshold"` // 统计窗口内错误率达到该值时熔断，0 表示不按错误率熔断
+	MinRequests         int     `json:"min_requests"`         // 按错误率熔断所需的窗口内最少请求数
+	WindowSeconds       int     `json:"window_seconds"`
+	OpenSeconds         int     `json:"open_seconds"`        // 熔断持续时间，之后进入半开状态
+	HalfOpenPercent     int     `json:"half_open_percent"`   // 半开状态下放行的流量百分比
+	HalfOpenSuccesses   int     `json:"half_open_successes"` // 半开状态下连续成功该次数后恢复
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:             false,
+	ConsecutiveFailures: 5,
+	ErrorRateThreshold:  0.5,
+	MinRequests:         20,
+	WindowSeconds:       60,
+	OpenSeconds:         30,
+	HalfOpenPercent:     10,
+	HalfOpenSuccesses:   3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
@@ -0,0 +1,60 @@
+package operation_setting
+
+import (
+	"one-api/common"
+	"one-api/setting/config"
+)
+
+// GrayLogicSetting 高负载降级配置：当前活跃请求数超过阈值时改写聊天请求参数
+type GrayLogicSetting struct {
+	Enabled              bool     `json:"enabled"`
+	Groups               []string `json:"groups"` // 生效的分组，为空时对所有分组生效
+	ConcurrencyThreshold int64    `json:"concurrency_threshold"`
+	MaxTokens            int      `json:"max_tokens"` // 降级后 max_tokens 的上限，0 表示不限制
+	TemperatureEnabled   bool     `json:"temperature_enabled"`
+	Temperature          float64  `json:"temperature"`
+}
+
+// 默认配置
+var grayLogicSetting = GrayLogicSetting{
+	Enabled:              false,
+	Groups:               []string{},
+	ConcurrencyThreshold: 50,
+	MaxTokens:            100,
+	TemperatureEnabled:   true,
+	Temperature:          1.2,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("gray_logic_setting", &grayLogicSetting)
+}
+
+func GetGrayLogicSetting() *GrayLogicSetting {
+	return &grayLogicSetting
+}
+
+// IsOverloaded 当前活跃请求数是否超过降级阈值
+func (s *GrayLogicSetting) IsOverloaded() bool {
+	return common.GetActiveRequests() > s.ConcurrencyThreshold
+}
+
+// ShouldActivate 判断指定分组当前是否应该激活降级
+func (s *GrayLogicSetting) ShouldActivate(group string) bool {
+	if !s.Enabled {
+		return false
+	}
+	if len(s.Groups) > 0 {
+		enabled := false
+		for _, g := range s.Groups {
+			if g == group {
+				enabled = true
+				break
+			}
+		}
+		if !enabled {
+			return false
+		}
+	}
+	return s.IsOverloaded()
+}
@@ -0,0 +1,34 @@
+package operation_setting
+
+import (
+	"one-api/setting/config"
+	"slices"
+)
+
+// HedgeSetting 对冲请求：首个渠道在 DelayMs 内没有返回响应时，向同一模型的另一个渠道再发一次请求
+// 先向客户端写出响应内容的请求胜出，另一个请求被取消且不计费，仅对列出的分组生效；对冲的流式请求不发送保活 ping
+type HedgeSetting struct {
+	Enabled bool     `json:"enabled"`
+	Groups  []string `json:"groups"`
+	DelayMs int      `json:"delay_ms"`
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled: false,
+	Groups:  []string{},
+	DelayMs: 2000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_setting", &hedgeSetting)
+}
+
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+func (s *HedgeSetting) IsGroupEnabled(group string) bool {
+	return s.Enabled && s.DelayMs > 0 && slices.Contains(s.Groups, group)
+}
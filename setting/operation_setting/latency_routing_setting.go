@@ -0,0 +1,27 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// LatencyRoutingSetting 延迟感知的渠道选择：在同一优先级内按实时首字时间与错误率调整权重
+// 仅在启用内存缓存时生效
+type LatencyRoutingSetting struct {
+	Enabled    bool    `json:"enabled"`
+	Alpha      float64 `json:"alpha"`       // EWMA 平滑系数，越大越偏向最近的请求
+	MinSamples int     `json:"min_samples"` // 样本数达到该值后才参与权重调整
+}
+
+// 默认配置
+var latencyRoutingSetting = LatencyRoutingSetting{
+	Enabled:    false,
+	Alpha:      0.2,
+	MinSamples: 5,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("latency_routing_setting", &latencyRoutingSetting)
+}
+
+func GetLatencyRoutingSetting() *LatencyRoutingSetting {
+	return &latencyRoutingSetting
+}
@@ -0,0 +1,46 @@
+package operation_setting
+
+import (
+	"one-api/setting/config"
+	"slices"
+)
+
+const (
+	PromptAffinityModePrefix = "prefix" // 按整个提示词（含系统提示词）的前 PrefixTokens 个 token 哈希
+	PromptAffinityModeSystem = "system" // 按系统提示词哈希，请求没有系统提示词时按前缀哈希
+)
+
+// PromptAffinitySetting 提示词前缀亲和路由：相同前缀的请求通过一致性哈希落到同一渠道，以提高上游提示词缓存的命中率
+// 目标渠道不可用时沿哈希环顺延到下一个渠道，仅在启用内存缓存时生效
+type PromptAffinitySetting struct {
+	Enabled      bool     `json:"enabled"`
+	Groups       []string `json:"groups"` // 生效的分组，为空表示所有分组
+	Mode         string   `json:"mode"`
+	PrefixTokens int      `json:"prefix_tokens"`
+	VirtualNodes int      `json:"virtual_nodes"` // 每个渠道在哈希环上的虚拟节点数
+}
+
+// 默认配置
+var promptAffinitySetting = PromptAffinitySetting{
+	Enabled:      false,
+	Groups:       []string{},
+	Mode:         PromptAffinityModePrefix,
+	PrefixTokens: 1024,
+	VirtualNodes: 160,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("prompt_affinity_setting", &promptAffinitySetting)
+}
+
+func GetPromptAffinitySetting() *PromptAffinitySetting {
+	return &promptAffinitySetting
+}
+
+func (s *PromptAffinitySetting) IsGroupEnabled(group string) bool {
+	if !s.Enabled || s.PrefixTokens <= 0 {
+		return false
+	}
+	return len(s.Groups) == 0 || slices.Contains(s.Groups, group)
+}
@@ -0,0 +1,64 @@
+package operation_setting
+
+import (
+	"math/rand"
+	"one-api/setting/config"
+	"slices"
+)
+
+// ShadowSetting 影子流量：按比例将真实请求镜像到影子渠道，影子渠道的响应不返回给客户端、不向用户计费
+// 主渠道与影子渠道的延迟、状态码、用量以及可选的输出内容写入对比记录，用于评估新渠道
+type ShadowSetting struct {
+	Enabled bool         `json:"enabled"`
+	Rules   []ShadowRule `json:"rules"`
+	// 影子请求的超时时间，与客户端请求的生命周期无关
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// 记录输出内容时最多保存的字符数
+	MaxContentLength int `json:"max_content_length"`
+}
+
+// ShadowRule 一条镜像规则，Models 与 Groups 为空时匹配全部模型或分组
+type ShadowRule struct {
+	ChannelId     int      `json:"channel_id"`
+	Models        []string `json:"models"`
+	Groups        []string `json:"groups"`
+	Percentage    float64  `json:"percentage"` // 0-100
+	RecordContent bool     `json:"record_content"`
+}
+
+// 默认配置
+var shadowSetting = ShadowSetting{
+	Enabled:          false,
+	Rules:            []ShadowRule{},
+	TimeoutSeconds:   300,
+	MaxContentLength: 4096,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("shadow_setting", &shadowSetting)
+}
+
+func GetShadowSetting() *ShadowSetting {
+	return &shadowSetting
+}
+
+// SampleShadowRule 按规则顺序取第一条匹配分组与模型的规则，并按其比例抽样，未命中时返回 false
+func (s *ShadowSetting) SampleShadowRule(group string, modelName string) (ShadowRule, bool) {
+	if !s.Enabled {
+		return ShadowRule{}, false
+	}
+	for _, rule := range s.Rules {
+		if rule.ChannelId == 0 || rule.Percentage <= 0 {
+			continue
+		}
+		if len(rule.Models) > 0 && !slices.Contains(rule.Models, modelName) {
+			continue
+		}
+		if len(rule.Groups) > 0 && !slices.Contains(rule.Groups, group) {
+			continue
+		}
+		return rule, rand.Float64()*100 < rule.Percentage
+	}
+	return ShadowRule{}, false
+}
@@ -0,0 +1,28 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// StickyRoutingSetting 会话粘性路由配置：同一会话在 TTL 内保持相同的解析模型与渠道
+type StickyRoutingSetting struct {
+	Enabled       bool   `json:"enabled"`
+	SessionHeader string `json:"session_header"` // 客户端提供的会话标识请求头，未提供时按令牌与前几条消息的哈希识别会话
+	HashMessages  int    `json:"hash_messages"`  // 参与哈希的前几条消息数量，0 表示不按消息哈希
+	TTLSeconds    int    `json:"ttl_seconds"`
+}
+
+// 默认配置
+var stickyRoutingSetting = StickyRoutingSetting{
+	Enabled:       false,
+	SessionHeader: "X-Session-Id",
+	HashMessages:  2,
+	TTLSeconds:    3600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("sticky_routing_setting", &stickyRoutingSetting)
+}
+
+func GetStickyRoutingSetting() *StickyRoutingSetting {
+	return &stickyRoutingSetting
+}
@@ -0,0 +1,29 @@
+package ratio_setting
+
+import "one-api/common"
+
+// 价格表中的模型通配符索引，与对应的价格表使用同一把锁
+var (
+	modelPricePatterns      *common.ModelPatternIndex
+	modelRatioPatterns      *common.ModelPatternIndex
+	completionRatioPatterns *common.ModelPatternIndex
+)
+
+func newRatioPatternIndex(ratios map[string]float64) *common.ModelPatternIndex {
+	names := make([]string, 0, len(ratios))
+	for name := range ratios {
+		names = append(names, name)
+	}
+	return common.NewModelPatternIndex(names)
+}
+
+// resolveModelPattern 价格表中没有精确的模型名时返回最先匹配的模型通配符，都不匹配时返回模型名本身
+func resolveModelPattern(name string, ratios map[string]float64, patterns *common.ModelPatternIndex) string {
+	if _, ok := ratios[name]; ok {
+		return name
+	}
+	if matched := patterns.Match(name); len(matched) > 0 {
+		return matched[0]
+	}
+	return name
+}
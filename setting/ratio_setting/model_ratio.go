@@ -277,16 +277,19 @@ func InitRatioSettings() {
 	// Initialize modelPriceMap
 	modelPriceMapMutex.Lock()
 	modelPriceMap = defaultModelPrice
+	modelPricePatterns = newRatioPatternIndex(modelPriceMap)
 	modelPriceMapMutex.Unlock()
 
 	// Initialize modelRatioMap
 	modelRatioMapMutex.Lock()
 	modelRatioMap = defaultModelRatio
+	modelRatioPatterns = newRatioPatternIndex(modelRatioMap)
 	modelRatioMapMutex.Unlock()
 
 	// Initialize CompletionRatio
 	CompletionRatioMutex.Lock()
 	CompletionRatio = defaultCompletionRatio
+	completionRatioPatterns = newRatioPatternIndex(CompletionRatio)
 	CompletionRatioMutex.Unlock()
 
 	// Initialize cacheRatioMap
@@ -323,6 +326,7 @@ func UpdateModelPriceByJSONString(jsonStr string) error {
 	defer modelPriceMapMutex.Unlock()
 	modelPriceMap = make(map[string]float64)
 	err := json.Unmarshal([]byte(jsonStr), &modelPriceMap)
+	modelPricePatterns = newRatioPatternIndex(modelPriceMap)
 	if err == nil {
 		InvalidateExposedDataCache()
 	}
@@ -334,12 +338,7 @@ func GetModelPrice(name string, printErr bool) (float64, bool) {
 	modelPriceMapMutex.RLock()
 	defer modelPriceMapMutex.RUnlock()
 
-	if strings.HasPrefix(name, "gpt-4-gizmo") {
-		name = "gpt-4-gizmo-*"
-	}
-	if strings.HasPrefix(name, "gpt-4o-gizmo") {
-		name = "gpt-4o-gizmo-*"
-	}
+	name = resolveModelPattern(name, modelPriceMap, modelPricePatterns)
 	price, ok := modelPriceMap[name]
 	if !ok {
 		if printErr {
@@ -355,6 +354,7 @@ func UpdateModelRatioByJSONString(jsonStr string) error {
 	defer modelRatioMapMutex.Unlock()
 	modelRatioMap = make(map[string]float64)
 	err := json.Unmarshal([]byte(jsonStr), &modelRatioMap)
+	modelRatioPatterns = newRatioPatternIndex(modelRatioMap)
 	if err == nil {
 		InvalidateExposedDataCache()
 	}
@@ -375,9 +375,7 @@ func GetModelRatio(name string) (float64, bool) {
 
 	name = handleThinkingBudgetModel(name, "gemini-2.5-flash", "gemini-2.5-flash-thinking-*")
 	name = handleThinkingBudgetModel(name, "gemini-2.5-pro", "gemini-2.5-pro-thinking-*")
-	if strings.HasPrefix(name, "gpt-4-gizmo") {
-		name = "gpt-4-gizmo-*"
-	}
+	name = resolveModelPattern(name, modelRatioMap, modelRatioPatterns)
 	ratio, ok := modelRatioMap[name]
 	if !ok {
 		return 37.5, operation_setting.SelfUseModeEnabled
@@ -419,6 +417,7 @@ func UpdateCompletionRatioByJSONString(jsonStr string) error {
 	defer CompletionRatioMutex.Unlock()
 	CompletionRatio = make(map[string]float64)
 	err := json.Unmarshal([]byte(jsonStr), &CompletionRatio)
+	completionRatioPatterns = newRatioPatternIndex(CompletionRatio)
 	if err == nil {
 		InvalidateExposedDataCache()
 	}
@@ -428,12 +427,7 @@ func UpdateCompletionRatioByJSONString(jsonStr string) error {
 func GetCompletionRatio(name string) float64 {
 	CompletionRatioMutex.RLock()
 	defer CompletionRatioMutex.RUnlock()
-	if strings.HasPrefix(name, "gpt-4-gizmo") {
-		name = "gpt-4-gizmo-*"
-	}
-	if strings.HasPrefix(name, "gpt-4o-gizmo") {
-		name = "gpt-4o-gizmo-*"
-	}
+	name = resolveModelPattern(name, CompletionRatio, completionRatioPatterns)
 	if strings.Contains(name, "/") {
 		if ratio, ok := CompletionRatio[name]; ok {
 			return ratio
@@ -0,0 +1,61 @@
+package ratio_setting
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"sync"
+)
+
+// policyPrice 虚拟策略模型的固定价格（美元/次），配置后按策略自身价格计费，而不是按解析出的成员模型计费
+var policyPrice = map[string]float64{}
+var policyPriceMutex sync.RWMutex
+
+func PolicyPrice2JSONString() string {
+	policyPriceMutex.RLock()
+	defer policyPriceMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(policyPrice)
+	if err != nil {
+		common.SysError("error marshalling policy price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdatePolicyPriceByJSONString(jsonStr string) error {
+	newPolicyPrice := make(map[string]float64)
+	if err := json.Unmarshal([]byte(jsonStr), &newPolicyPrice); err != nil {
+		return err
+	}
+	policyPriceMutex.Lock()
+	defer policyPriceMutex.Unlock()
+
+	policyPrice = newPolicyPrice
+	return nil
+}
+
+func CheckPolicyPrice(jsonStr string) error {
+	checkPolicyPrice := make(map[string]float64)
+	err := json.Unmarshal([]byte(jsonStr), &checkPolicyPrice)
+	if err != nil {
+		return err
+	}
+	for name, price := range checkPolicyPrice {
+		if price < 0 {
+			return errors.New("policy price must be not less than 0: " + name)
+		}
+	}
+	return nil
+}
+
+// GetPolicyPrice 返回虚拟策略模型的固定价格，未配置时返回 -1，false
+func GetPolicyPrice(name string) (float64, bool) {
+	policyPriceMutex.RLock()
+	defer policyPriceMutex.RUnlock()
+
+	price, ok := policyPrice[name]
+	if !ok {
+		return -1, false
+	}
+	return price, true
+}
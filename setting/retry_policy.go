@@ -0,0 +1,185 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"one-api/constant"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy 中继失败后的重试策略，按顺序取第一条匹配的规则，没有匹配的规则时按 DefaultRetry 决定是否重试
+// 本地错误、指定渠道的请求与重试次数用尽时始终不重试
+type RetryPolicy struct {
+	Rules        []RetryRule `json:"rules"`
+	DefaultRetry bool        `json:"default_retry"`
+}
+
+// RetryRule 重试规则，各匹配条件之间为与，条件内的多个取值之间为或，未填写的条件不参与匹配
+type RetryRule struct {
+	StatusCodes  []string `json:"status_codes,omitempty"`  // 状态码，支持 429、5xx 与 500-503 三种写法
+	ErrorTypes   []string `json:"error_types,omitempty"`   // 错误的 type 字段
+	ErrorCodes   []string `json:"error_codes,omitempty"`   // 错误的 code 字段
+	Keywords     []string `json:"keywords,omitempty"`      // 错误信息包含的关键词，不区分大小写
+	ChannelTypes []int    `json:"channel_types,omitempty"` // 出错渠道的类型
+
+	Retry bool `json:"retry"`
+	// 下一次重试切换到更低的优先级，否则停留在当前优先级重新选择渠道
+	SwitchTier bool `json:"switch_tier"`
+	// 重试前等待 BackoffMs × 2^(第几次重试-1)，不超过 MaxBackoffMs，并随机减少至多 Jitter 比例的时间
+	BackoffMs    int     `json:"backoff_ms,omitempty"`
+	MaxBackoffMs int     `json:"max_backoff_ms,omitempty"`
+	Jitter       float64 `json:"jitter,omitempty"`
+}
+
+// 默认策略与原先硬编码的规则一致
+var retryPolicy = RetryPolicy{
+	Rules: []RetryRule{
+		{StatusCodes: []string{"429", "307"}, Retry: true, SwitchTier: true},
+		// 超时不重试
+		{StatusCodes: []string{"504", "524"}, Retry: false},
+		{StatusCodes: []string{"5xx"}, Retry: true, SwitchTier: true},
+		{StatusCodes: []string{"400"}, ChannelTypes: []int{constant.ChannelTypeAnthropic}, Retry: true, SwitchTier: true},
+		{StatusCodes: []string{"400"}, Retry: false},
+		// azure处理超时不重试
+		{StatusCodes: []string{"408"}, Retry: false},
+		{StatusCodes: []string{"2xx"}, Retry: false},
+	},
+	DefaultRetry: true,
+}
+var retryPolicyMutex sync.RWMutex
+
+func RetryPolicy2JSONString() string {
+	retryPolicyMutex.RLock()
+	defer retryPolicyMutex.RUnlock()
+	jsonBytes, err := json.Marshal(retryPolicy)
+	if err != nil {
+		common.SysError("error marshalling retry policy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func parseRetryPolicy(jsonStr string) (*RetryPolicy, error) {
+	policy := &RetryPolicy{}
+	if err := json.Unmarshal([]byte(jsonStr), policy); err != nil {
+		return nil, err
+	}
+	for i, rule := range policy.Rules {
+		for _, pattern := range rule.StatusCodes {
+			if _, _, err := parseStatusCodePattern(pattern); err != nil {
+				return nil, fmt.Errorf("第 %d 条重试规则的状态码 %s 无效", i+1, pattern)
+			}
+		}
+		if rule.BackoffMs < 0 || rule.MaxBackoffMs < 0 || rule.Jitter < 0 || rule.Jitter > 1 {
+			return nil, fmt.Errorf("第 %d 条重试规则的退避配置无效", i+1)
+		}
+	}
+	return policy, nil
+}
+
+func CheckRetryPolicy(jsonStr string) error {
+	_, err := parseRetryPolicy(jsonStr)
+	return err
+}
+
+func UpdateRetryPolicyByJSONString(jsonStr string) error {
+	policy, err := parseRetryPolicy(jsonStr)
+	if err != nil {
+		return err
+	}
+	retryPolicyMutex.Lock()
+	defer retryPolicyMutex.Unlock()
+	retryPolicy = *policy
+	return nil
+}
+
+// parseStatusCodePattern 将状态码规则解析为闭区间
+func parseStatusCodePattern(pattern string) (int, int, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if len(pattern) == 3 && strings.HasSuffix(pattern, "xx") {
+		digit, err := strconv.Atoi(pattern[:1])
+		if err != nil {
+			return 0, 0, err
+		}
+		return digit * 100, digit*100 + 99, nil
+	}
+	if start, end, ok := strings.Cut(pattern, "-"); ok {
+		low, err := strconv.Atoi(start)
+		if err != nil {
+			return 0, 0, err
+		}
+		high, err := strconv.Atoi(end)
+		if err != nil {
+			return 0, 0, err
+		}
+		return low, high, nil
+	}
+	code, err := strconv.Atoi(pattern)
+	return code, code, err
+}
+
+// RetryError 参与重试规则匹配的错误信息
+type RetryError struct {
+	StatusCode  int
+	Type        string
+	Code        string
+	Message     string
+	ChannelType int
+}
+
+func (rule *RetryRule) match(retryErr *RetryError) bool {
+	if len(rule.StatusCodes) > 0 && !slices.ContainsFunc(rule.StatusCodes, func(pattern string) bool {
+		low, high, err := parseStatusCodePattern(pattern)
+		return err == nil && retryErr.StatusCode >= low && retryErr.StatusCode <= high
+	}) {
+		return false
+	}
+	if len(rule.ErrorTypes) > 0 && !slices.Contains(rule.ErrorTypes, retryErr.Type) {
+		return false
+	}
+	if len(rule.ErrorCodes) > 0 && !slices.Contains(rule.ErrorCodes, retryErr.Code) {
+		return false
+	}
+	if len(rule.Keywords) > 0 {
+		message := strings.ToLower(retryErr.Message)
+		if !slices.ContainsFunc(rule.Keywords, func(keyword string) bool {
+			return strings.Contains(message, strings.ToLower(keyword))
+		}) {
+			return false
+		}
+	}
+	if len(rule.ChannelTypes) > 0 && !slices.Contains(rule.ChannelTypes, retryErr.ChannelType) {
+		return false
+	}
+	return true
+}
+
+// GetRetryRule 返回第一条匹配错误的规则，没有匹配的规则时按 DefaultRetry 返回一条切换优先级、不等待的规则
+func GetRetryRule(retryErr *RetryError) RetryRule {
+	retryPolicyMutex.RLock()
+	defer retryPolicyMutex.RUnlock()
+	for _, rule := range retryPolicy.Rules {
+		if rule.match(retryErr) {
+			return rule
+		}
+	}
+	return RetryRule{Retry: retryPolicy.DefaultRetry, SwitchTier: true}
+}
+
+// GetBackoff 第 attempt 次重试（从 1 开始）前需要等待的时间
+func (rule *RetryRule) GetBackoff(attempt int) time.Duration {
+	if rule.BackoffMs <= 0 || attempt <= 0 {
+		return 0
+	}
+	backoff := float64(rule.BackoffMs) * float64(int64(1)<<min(attempt-1, 20))
+	if rule.MaxBackoffMs > 0 {
+		backoff = min(backoff, float64(rule.MaxBackoffMs))
+	}
+	backoff -= backoff * rule.Jitter * rand.Float64()
+	return time.Duration(backoff * float64(time.Millisecond))
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/router"
+	"one-api/service"
+	"one-api/setting/ratio_setting"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+// simulateOptions simulate 子命令的参数
+type simulateOptions struct {
+	tracePath       string
+	group           string
+	concurrency     int
+	latency         time.Duration
+	errorRate       float64
+	completionToken int
+}
+
+// simulateResult 一次回放请求的结果
+type simulateResult struct {
+	model    string
+	status   int
+	degraded bool
+}
+
+// runSimulate 将 JSONL 请求轨迹回放到完整的分发、渠道选择与计费流程中，上游为本地模拟服务
+// 渠道、虚拟策略与配置从当前数据库读取，日志与额度写入临时 SQLite 数据库，不会影响线上数据
+func runSimulate(args []string) {
+	opts := simulateOptions{}
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	flags.StringVar(&opts.tracePath, "trace", "requests.jsonl", "JSONL request trace, one chat completion request body per line")
+	flags.StringVar(&opts.group, "group", "default", "user group used for the simulated requests")
+	flags.IntVar(&opts.concurrency, "concurrency", 10, "number of concurrent requests")
+	flags.DurationVar(&opts.latency, "latency", 200*time.Millisecond, "mock upstream latency per request")
+	flags.Float64Var(&opts.errorRate, "error-rate", 0, "probability that the mock upstream returns a 500 error")
+	flags.IntVar(&opts.completionToken, "completion-tokens", 200, "completion tokens returned by the mock upstream, capped by max_tokens")
+	_ = flags.Parse(args)
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+
+	bodies, err := readSimulateTrace(opts.tracePath)
+	if err != nil {
+		common.FatalLog("failed to read trace: " + err.Error())
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveSimulateUpstream(w, r, opts)
+	}))
+	defer upstream.Close()
+
+	sqlitePath := filepath.Join(os.TempDir(), fmt.Sprintf("new-api-simulate-%d.db", time.Now().UnixNano()))
+	defer os.Remove(sqlitePath)
+	tokenKey, err := initSimulateResources(sqlitePath, upstream.URL, opts.group)
+	if err != nil {
+		common.FatalLog("failed to initialize simulation: " + err.Error())
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	server := gin.New()
+	router.SetRelayRouter(server)
+
+	results := make([]simulateResult, len(bodies))
+	var next int64 = -1
+	var wg sync.WaitGroup
+	startTime := time.Now()
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				idx := int(atomic.AddInt64(&next, 1))
+				if idx >= len(bodies) {
+					return
+				}
+				results[idx] = replaySimulateRequest(server, tokenKey, bodies[idx])
+			}
+		}()
+	}
+	wg.Wait()
+
+	printSimulateReport(opts, results, time.Since(startTime))
+}
+
+func readSimulateTrace(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var bodies [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		bodies = append(bodies, append([]byte(nil), line...))
+	}
+	return bodies, scanner.Err()
+}
+
+// initSimulateResources 以只读方式读取线上配置后切换到临时数据库，并创建模拟用户与令牌，返回令牌 key
+func initSimulateResources(sqlitePath string, upstreamURL string, group string) (string, error) {
+	_ = godotenv.Load(".env")
+	common.InitEnv()
+	common.SetupLogger()
+	ratio_setting.InitRatioSettings()
+	service.InitHttpClient()
+	service.InitTokenEncoders()
+	if err := model.OpenSimulationSourceDB(); err != nil {
+		return "", err
+	}
+	model.InitOptionMap()
+
+	err := model.InitSimulationDB(sqlitePath, func(channel *model.Channel) {
+		// 所有渠道都指向本地模拟上游，按 OpenAI 格式请求
+		channel.Type = constant.ChannelTypeOpenAI
+		channel.BaseURL = &upstreamURL
+		channel.Key = "sk-simulate"
+		channel.Setting = nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	user := model.User{
+		Username:    "simulate",
+		Password:    common.GetRandomString(16),
+		DisplayName: "simulate",
+		Role:        common.RoleCommonUser,
+		Status:      common.UserStatusEnabled,
+		Quota:       1 << 40,
+		Group:       group,
+		AffCode:     common.GetRandomString(4),
+	}
+	if err := model.DB.Create(&user).Error; err != nil {
+		return "", err
+	}
+	key, err := common.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	token := model.Token{
+		UserId:         user.Id,
+		Key:            key,
+		Status:         common.TokenStatusEnabled,
+		Name:           "simulate",
+		CreatedTime:    common.GetTimestamp(),
+		ExpiredTime:    -1,
+		UnlimitedQuota: true,
+	}
+	if err := token.Insert(); err != nil {
+		return "", err
+	}
+
+	// 模拟只使用内存缓存，不读写 Redis
+	common.RedisEnabled = false
+	common.MemoryCacheEnabled = true
+	common.LogConsumeEnabled = true
+	model.InitChannelCache()
+	model.InitPolicyCache()
+	return key, nil
+}
+
+func replaySimulateRequest(server *gin.Engine, tokenKey string, body []byte) simulateResult {
+	var request struct {
+		Model string `json:"model"`
+	}
+	_ = json.Unmarshal(body, &request)
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk-"+tokenKey)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	return simulateResult{
+		model:    request.Model,
+		status:   recorder.Code,
+		degraded: recorder.Header().Get("X-New-Api-Degraded") != "",
+	}
+}
+
+// serveSimulateUpstream 模拟 OpenAI 兼容的上游，按 max_tokens 截断返回的补全 token 数
+func serveSimulateUpstream(w http.ResponseWriter, r *http.Request, opts simulateOptions) {
+	var request struct {
+		Model               string            `json:"model"`
+		Stream              bool              `json:"stream"`
+		MaxTokens           int               `json:"max_tokens"`
+		MaxCompletionTokens int               `json:"max_completion_tokens"`
+		Messages            []json.RawMessage `json:"messages"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&request)
+	time.Sleep(opts.latency)
+	if opts.errorRate > 0 && rand.Float64() < opts.errorRate {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"message":"simulated upstream error","type":"server_error"}}`))
+		return
+	}
+
+	promptTokens := 0
+	for _, message := range request.Messages {
+		promptTokens += len(message)/4 + 1
+	}
+	completionTokens := opts.completionToken
+	maxTokens := request.MaxTokens
+	if request.MaxCompletionTokens > 0 {
+		maxTokens = request.MaxCompletionTokens
+	}
+	if maxTokens > 0 && completionTokens > maxTokens {
+		completionTokens = maxTokens
+	}
+	usage := map[string]int{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
+	}
+	id := "chatcmpl-simulate-" + common.GetRandomString(8)
+	content := strings.TrimSpace(strings.Repeat("ok ", completionTokens))
+	if request.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunk, _ := json.Marshal(map[string]any{
+			"id": id, "object": "chat.completion.chunk", "created": time.Now().Unix(), "model": request.Model,
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"role": "assistant", "content": content}}},
+		})
+		final, _ := json.Marshal(map[string]any{
+			"id": id, "object": "chat.completion.chunk", "created": time.Now().Unix(), "model": request.Model,
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{}, "finish_reason": "stop"}},
+			"usage":   usage,
+		})
+		_, _ = fmt.Fprintf(w, "data: %s\n\ndata: %s\n\ndata: [DONE]\n\n", chunk, final)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id": id, "object": "chat.completion", "created": time.Now().Unix(), "model": request.Model,
+		"choices": []any{map[string]any{"index": 0, "message": map[string]any{"role": "assistant", "content": content}, "finish_reason": "stop"}},
+		"usage":   usage,
+	})
+}
+
+// simulateModelReport 按请求模型汇总的分布与费用
+type simulateModelReport struct {
+	requests int
+	failed   int
+	degraded int
+	strategy string
+	quota    int
+	resolved map[string]int
+}
+
+func printSimulateReport(opts simulateOptions, results []simulateResult, elapsed time.Duration) {
+	reports := make(map[string]*simulateModelReport)
+	getReport := func(modelName string) *simulateModelReport {
+		report, ok := reports[modelName]
+		if !ok {
+			report = &simulateModelReport{resolved: make(map[string]int)}
+			reports[modelName] = report
+		}
+		return report
+	}
+	failed, degraded := 0, 0
+	for _, result := range results {
+		report := getReport(result.model)
+		report.requests++
+		if result.status != http.StatusOK {
+			report.failed++
+			failed++
+		}
+		if result.degraded {
+			report.degraded++
+			degraded++
+		}
+	}
+
+	// 解析模型与费用以消费日志为准
+	var logs []*model.Log
+	model.LOG_DB.Where("type = ?", model.LogTypeConsume).Find(&logs)
+	for _, log := range logs {
+		requestedModel := log.ModelName
+		other := common.StrToMap(log.Other)
+		if loser, _ := other["hedge_loser"].(bool); loser {
+			// 未被采用的对冲请求不计入分布
+			continue
+		}
+		if name, ok := other["requested_model"].(string); ok && name != "" {
+			requestedModel = name
+		}
+		report := getReport(requestedModel)
+		if strategy, ok := other["policy_strategy"].(string); ok {
+			report.strategy = strategy
+		}
+		report.quota += log.Quota
+		report.resolved[log.ModelName]++
+	}
+
+	total := len(results)
+	fmt.Printf("simulated %d requests in %s, concurrency %d, group %s\n", total, elapsed.Round(time.Millisecond), opts.concurrency, opts.group)
+	fmt.Printf("failed: %d (%s), degraded: %d (%s)\n", failed, simulatePercent(failed, total), degraded, simulatePercent(degraded, total))
+
+	modelNames := make([]string, 0, len(reports))
+	for modelName := range reports {
+		modelNames = append(modelNames, modelName)
+	}
+	sort.Strings(modelNames)
+	for _, modelName := range modelNames {
+		report := reports[modelName]
+		cost := float64(report.quota) / common.QuotaPerUnit
+		title := modelName
+		if report.strategy != "" {
+			title = fmt.Sprintf("%s (%s)", modelName, report.strategy)
+		}
+		fmt.Printf("\n%s\n", title)
+		fmt.Printf("  requests: %d, failed: %d, degraded: %d (%s)\n", report.requests, report.failed, report.degraded, simulatePercent(report.degraded, report.requests))
+		succeeded := report.requests - report.failed
+		avgCost := 0.0
+		if succeeded > 0 {
+			avgCost = cost / float64(succeeded)
+		}
+		fmt.Printf("  estimated cost: $%.6f, per request: $%.6f\n", cost, avgCost)
+		resolvedModels := make([]string, 0, len(report.resolved))
+		for resolvedModel := range report.resolved {
+			resolvedModels = append(resolvedModels, resolvedModel)
+		}
+		sort.Strings(resolvedModels)
+		for _, resolvedModel := range resolvedModels {
+			count := report.resolved[resolvedModel]
+			fmt.Printf("  -> %-40s %6d  %s\n", resolvedModel, count, simulatePercent(count, succeeded))
+		}
+	}
+}
+
+func simulatePercent(count int, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(count)*100/float64(total))
+}